@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// stringList is a flag that may be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// headerList is a repeatable flag of "Name: value" headers
+type headerList struct {
+	header http.Header
+}
+
+func (l *headerList) String() string {
+	var headers []string
+	for name, values := range l.header {
+		for _, value := range values {
+			headers = append(headers, name+": "+value)
+		}
+	}
+	return strings.Join(headers, ", ")
+}
+
+func (l *headerList) Set(value string) error {
+	name, val, ok := strings.Cut(value, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return fmt.Errorf("invalid header %q, expected \"Name: value\"", value)
+	}
+
+	if l.header == nil {
+		l.header = make(http.Header)
+	}
+	l.header.Add(name, strings.TrimSpace(val))
+	return nil
+}
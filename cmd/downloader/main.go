@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
 	"runtime"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/godownloader/pkg/downloader"
 )
@@ -17,11 +22,51 @@ var (
 
 func main() {
 	// Parse command-line flags
-	url := flag.String("url", "", "URL to download (required)")
-	output := flag.String("output", "", "Output file path (default: filename from URL)")
+	var urls stringList
+	flag.Var(&urls, "url", "URL to download (required); repeat to add mirrors of the same file")
+	output := flag.String("output", "", "Output file path (default: name from Content-Disposition or URL)")
+	outputDir := flag.String("O", "", "Directory to save into, keeping the name from Content-Disposition or URL")
+	onExists := flag.String("on-exists", "overwrite", "What to do if the output file exists: overwrite, skip (if current), fail, rename or resume")
+	input := flag.String("i", "", "File listing URLs to download, one per line as: URL [output] [algorithm:hexdigest]; - reads stdin")
+	jobs := flag.Int("jobs", 3, "Number of files downloaded at once with -i")
 	threads := flag.Int("threads", runtime.NumCPU(), "Number of download threads (default: number of CPU cores)")
 	maxRetries := flag.Int("retries", 3, "Maximum number of retries for failed chunks")
+	retryPolicy := downloader.DefaultRetryPolicy()
+	flag.IntVar(&retryPolicy.MaxAttempts, "attempts", retryPolicy.MaxAttempts, "Attempts per HTTP request before a chunk fails")
+	flag.DurationVar(&retryPolicy.BaseDelay, "backoff", retryPolicy.BaseDelay, "Delay before the first retry of a request, doubled on each further retry")
+	flag.DurationVar(&retryPolicy.MaxDelay, "max-backoff", retryPolicy.MaxDelay, "Longest delay between retries, unless the server asks for more with Retry-After")
 	quiet := flag.Bool("quiet", false, "Suppress output except for errors")
+	var headers headerList
+	flag.Var(&headers, "H", "Header added to every request as \"Name: value\"; may be repeated")
+	userAgent := flag.String("user-agent", "", "User-Agent sent with every request (default: Go-Downloader/1.0)")
+	user := flag.String("user", "", "Basic auth credentials as user:password")
+	bearer := flag.String("bearer", "", "Bearer token sent with every request")
+	cookies := flag.String("cookies", "", "Netscape cookies.txt file with cookies sent with every request")
+	netrc := flag.Bool("netrc", false, "Look up credentials for each host in $NETRC or ~/.netrc")
+	maxRedirects := flag.Int("max-redirects", 10, "Maximum number of redirects followed")
+	trustRedirects := flag.Bool("trust-redirects", false, "Also send credentials and -H headers to the hosts redirected to")
+	proxy := flag.String("proxy", "", "Proxy URL as http://, https:// or socks5://[user:password@]host:port (default: from HTTP_PROXY/HTTPS_PROXY)")
+	noProxy := flag.String("no-proxy", "", "Comma-separated hosts, domains and CIDR ranges reached without the proxy, from -proxy or the environment (default: NO_PROXY)")
+	var caFiles, pins stringList
+	flag.Var(&caFiles, "cacert", "PEM file of CA certificates trusted in addition to the system roots; may be repeated")
+	cert := flag.String("cert", "", "PEM file of the client certificate, and of its key unless -key is given")
+	key := flag.String("key", "", "PEM file of the client certificate's private key")
+	tlsMin := flag.String("tls-min", "", "Minimum TLS version: 1.0, 1.1, 1.2 or 1.3")
+	flag.Var(&pins, "pin", "Pinned public key as sha256//<base64 SPKI hash>; may be repeated")
+	insecure := flag.Bool("insecure", false, "Skip TLS certificate verification (dangerous)")
+	var timeouts downloader.Timeouts
+	flag.DurationVar(&timeouts.Dial, "connect-timeout", downloader.DefaultDialTimeout, "Time allowed to connect to a server; 0 disables")
+	flag.DurationVar(&timeouts.TLSHandshake, "tls-timeout", downloader.DefaultTLSHandshakeTimeout, "Time allowed for the TLS handshake; 0 disables")
+	flag.DurationVar(&timeouts.ResponseHeader, "header-timeout", downloader.DefaultResponseHeaderTimeout, "Time allowed for the response headers to arrive; 0 disables")
+	flag.DurationVar(&timeouts.Stall, "stall-timeout", downloader.DefaultStallTimeout, "Abort and retry a connection that receives no data for this long; 0 disables")
+	minSpeed := flag.String("min-speed", "", "Abort and retry a connection slower than this many bytes per second, e.g. 10K (default: none)")
+	flag.DurationVar(&timeouts.MinSpeedTime, "min-speed-time", downloader.DefaultMinSpeedTime, "How long a connection may stay below -min-speed")
+	remoteTime := flag.Bool("remote-time", false, "Set the modification time of the file to the server's Last-Modified")
+	preallocate := flag.Bool("preallocate", false, "Write chunks directly into the preallocated output file instead of merging temp files")
+	checksum := flag.String("checksum", "", "Expected checksum as algorithm:hexdigest (sha256, sha512, sha1, md5, blake2b)")
+	limit := flag.String("limit", "", "Maximum download speed in bytes per second, e.g. 500K or 5M (default: unlimited)")
+	progress := flag.String("progress", "bar", "Progress output: bar, or json for newline-delimited JSON events")
+	progressFD := flag.Int("progress-fd", 2, "File descriptor receiving -progress=json events (default: stderr)")
 	showVersion := flag.Bool("version", false, "Show version information")
 
 	flag.Parse()
@@ -34,10 +79,18 @@ func main() {
 	}
 
 	// Check for required URL parameter
-	if *url == "" {
+	if *input != "" && (len(urls) > 0 || *output != "") {
+		fmt.Println("Error: -i cannot be combined with -url or -output.")
+		os.Exit(1)
+	}
+	if *outputDir != "" && *output != "" {
+		fmt.Println("Error: -O cannot be combined with -output.")
+		os.Exit(1)
+	}
+	if len(urls) == 0 && *input == "" {
 		if len(flag.Args()) > 0 {
 			// Allow URL as positional argument
-			*url = flag.Args()[0]
+			urls = append(urls, flag.Args()[0])
 		} else {
 			fmt.Println("Error: URL is required.")
 			fmt.Println("\nUsage:")
@@ -46,23 +99,145 @@ func main() {
 		}
 	}
 
-	// Set up signal handling for clean termination
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	// Cancel the download on interrupt so workers stop and state is saved
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Create and configure downloader
+	options := downloader.DefaultOptions()
+	options.OutputPath = *output
+	options.OutputDir = *outputDir
+	options.NumThreads = *threads
+	options.MaxRetries = *maxRetries
+	options.RetryPolicy = retryPolicy
+	options.Verbose = !*quiet
+	options.Preallocate = *preallocate
+	options.PreserveModTime = *remoteTime
+	options.Headers = headers.header
+	options.UserAgent = *userAgent
+	options.Username, options.Password, _ = strings.Cut(*user, ":")
+	options.BearerToken = *bearer
+	options.Netrc = *netrc
+	options.MaxRedirects = *maxRedirects
+	if *maxRedirects == 0 {
+		options.MaxRedirects = -1
+	}
+	options.TrustRedirects = *trustRedirects
+	options.Proxy = *proxy
+	options.NoProxy = *noProxy
+
+	// A zero timeout disables it, which Timeouts spells as negative
+	for _, timeout := range []*time.Duration{&timeouts.Dial, &timeouts.TLSHandshake, &timeouts.ResponseHeader, &timeouts.Stall} {
+		if *timeout == 0 {
+			*timeout = -1
+		}
+	}
+	if *minSpeed != "" {
+		speed, err := downloader.ParseSize(*minSpeed)
+		if err != nil {
+			fmt.Printf("Error: -min-speed: %v\n", err)
+			os.Exit(1)
+		}
+		timeouts.MinSpeed = speed
+	}
+	options.Timeouts = timeouts
+
+	if *key != "" && *cert == "" {
+		fmt.Println("Error: -key requires -cert.")
+		os.Exit(1)
+	}
+	if len(caFiles) > 0 || *cert != "" || *tlsMin != "" || len(pins) > 0 || *insecure {
+		tlsOptions := &downloader.TLSOptions{
+			CAFiles:            caFiles,
+			PinnedKeys:         pins,
+			InsecureSkipVerify: *insecure,
+		}
+		if *cert != "" {
+			tlsOptions.ClientCerts = []downloader.ClientCert{{CertFile: *cert, KeyFile: *key}}
+		}
+		if *tlsMin != "" {
+			version, err := downloader.ParseTLSVersion(*tlsMin)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			tlsOptions.MinVersion = version
+		}
+		if *insecure {
+			fmt.Fprintln(os.Stderr, "WARNING: TLS certificate verification is disabled; the connection can be intercepted.")
+		}
+		options.TLS = tlsOptions
+	}
+
+	if *cookies != "" {
+		jar, err := downloader.LoadCookies(*cookies)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		options.Cookies = jar
+	}
+	if len(urls) > 1 {
+		options.Mirrors = urls[1:]
+	}
 
-	go func() {
-		<-sigChan
-		fmt.Println("\nDownload canceled. Cleaning up...")
+	policy, err := downloader.ParseExistsPolicy(*onExists)
+	if err != nil {
+		fmt.Printf("Error: -on-exists: %v\n", err)
 		os.Exit(1)
-	}()
+	}
+	options.OnExists = policy
 
-	// Create and configure downloader
-	dl := downloader.New(*url, *output, *threads)
-	dl.SetMaxRetries(*maxRetries)
-	dl.SetVerbose(!*quiet)
+	if *checksum != "" {
+		parsed, err := downloader.ParseChecksum(*checksum)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		options.Checksum = parsed
+	}
+
+	if *limit != "" {
+		rate, err := downloader.ParseSize(*limit)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		options.RateLimit = rate
+	}
+
+	switch *progress {
+	case "bar":
+	case "json":
+		// The JSON stream replaces the progress bar and always reports a hash
+		options.Verbose = false
+		progressFile, err := openProgressFD(*progressFD)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		options.OnProgress = jsonProgress(progressFile)
+		if options.Checksum == nil {
+			options.Checksum = &downloader.Checksum{Algorithm: "sha256"}
+		}
+	default:
+		fmt.Printf("Error: invalid -progress %q, expected bar or json\n", *progress)
+		os.Exit(1)
+	}
+
+	if *input != "" {
+		os.Exit(runBatch(ctx, *input, options, *jobs))
+	}
+
+	dl := downloader.WithOptions(urls[0], options)
 
 	// Start download
-	err := dl.Download()
+	err = dl.DownloadContext(ctx)
+
+	if errors.Is(err, context.Canceled) {
+		fmt.Println("\nDownload canceled. Run the same command again to resume.")
+		os.Exit(1)
+	}
 
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
@@ -71,3 +246,60 @@ func main() {
 
 	os.Exit(0)
 }
+
+// runBatch downloads every file listed in the input file and reports the
+// outcome of each, returning the exit code
+func runBatch(ctx context.Context, input string, options downloader.Options, jobs int) int {
+	var list io.Reader = os.Stdin
+	if input != "-" {
+		file, err := os.Open(input)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		list = file
+	}
+
+	items, err := downloader.ParseBatchList(list)
+	if err != nil {
+		fmt.Printf("Error: %s: %v\n", input, err)
+		return 1
+	}
+
+	// Progress bars of concurrent downloads would overwrite each other
+	if jobs > 1 {
+		options.Verbose = false
+	}
+
+	// -limit caps the whole batch, not each of the concurrent files
+	if options.RateLimit > 0 {
+		options.Limiter = downloader.NewLimiter(options.RateLimit)
+		options.RateLimit = 0
+	}
+
+	batch := downloader.NewBatch(options, jobs)
+	batch.Add(items...)
+	results := batch.Run(ctx)
+
+	var failed int
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+			fmt.Printf("FAILED %s: %v\n", result.Item.URL, result.Err)
+		} else if result.Result.Skipped {
+			fmt.Printf("SKIP   %s: %s is up to date\n", result.Item.URL, result.Result.OutputPath)
+		} else {
+			fmt.Printf("OK     %s\n", result.Item.URL)
+		}
+	}
+	fmt.Printf("\n%d of %d files downloaded, %d failed\n", len(results)-failed, len(results), failed)
+
+	if ctx.Err() != nil {
+		fmt.Println("Download canceled. Run the same command again to resume.")
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/godownloader/pkg/downloader"
+)
+
+// jsonEvent is one line of -progress=json output
+type jsonEvent struct {
+	Event      string    `json:"event"`
+	Time       time.Time `json:"time"`
+	URL        string    `json:"url,omitempty"`
+	Output     string    `json:"output,omitempty"`
+	TotalBytes int64     `json:"total_bytes,omitempty"`
+	Downloaded int64     `json:"downloaded_bytes"`
+	Speed      float64   `json:"speed_bytes_per_sec,omitempty"`
+	ETA        float64   `json:"eta_sec,omitempty"`
+	Chunk      *int      `json:"chunk,omitempty"`
+	Hash       string    `json:"hash,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Category   string    `json:"category,omitempty"`
+}
+
+// jsonEventNames maps the downloader's events to their JSON names; other
+// events are not reported
+var jsonEventNames = map[downloader.EventType]string{
+	downloader.EventProbing:       "start",
+	downloader.EventProgress:      "progress",
+	downloader.EventChunkComplete: "chunk_complete",
+	downloader.EventRetrying:      "chunk_retry",
+	downloader.EventDone:          "finished",
+	downloader.EventSkipped:       "skipped",
+	downloader.EventFailed:        "error",
+}
+
+// jsonProgress returns a progress callback writing newline-delimited JSON
+// events to w. It may be shared by concurrent downloads.
+func jsonProgress(w io.Writer) func(downloader.ProgressEvent) {
+	encoder := json.NewEncoder(w)
+	var mu sync.Mutex
+
+	return func(event downloader.ProgressEvent) {
+		name, ok := jsonEventNames[event.Type]
+		if !ok {
+			return
+		}
+
+		line := jsonEvent{
+			Event:      name,
+			Time:       time.Now().UTC(),
+			URL:        event.URL,
+			Output:     event.OutputPath,
+			TotalBytes: event.TotalSize,
+			Downloaded: event.Downloaded,
+		}
+
+		switch event.Type {
+		case downloader.EventProgress:
+			line.Speed = event.Speed
+			line.ETA = event.ETA.Seconds()
+		case downloader.EventChunkComplete, downloader.EventRetrying:
+			line.Chunk = &event.ChunkID
+			if event.Err != nil {
+				line.Error = event.Err.Error()
+			}
+		case downloader.EventDone, downloader.EventSkipped:
+			line.Hash = event.Checksum
+		case downloader.EventFailed:
+			line.Error = event.Err.Error()
+			line.Category = errorCategory(event.Err)
+		}
+
+		// A broken progress stream must not fail the download
+		mu.Lock()
+		_ = encoder.Encode(line)
+		mu.Unlock()
+	}
+}
+
+// openProgressFD returns the file receiving -progress=json events. A
+// descriptor that can't be written to is rejected up front, since the
+// stream ignores write errors and would silently drop every event.
+func openProgressFD(fd int) (*os.File, error) {
+	if fd < 0 {
+		return nil, fmt.Errorf("invalid -progress-fd %d", fd)
+	}
+
+	file := os.NewFile(uintptr(fd), "progress")
+	if _, err := file.Write(nil); err != nil {
+		return nil, fmt.Errorf("-progress-fd %d is not writable: %w", fd, err)
+	}
+	return file, nil
+}
+
+// errorCategory classifies a download error for machine consumers
+func errorCategory(err error) string {
+	var mismatch *downloader.ChecksumMismatchError
+	var status *downloader.StatusError
+	var netErr net.Error
+	var pathErr *fs.PathError
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, downloader.ErrStalled), errors.Is(err, downloader.ErrTooSlow):
+		return "timeout"
+	case errors.Is(err, downloader.ErrResourceChanged):
+		return "changed"
+	case errors.Is(err, downloader.ErrFileExists):
+		return "exists"
+	case errors.As(err, &mismatch):
+		return "checksum"
+	case errors.As(err, &status):
+		return "http"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &netErr):
+		return "network"
+	case errors.As(err, &pathErr):
+		return "filesystem"
+	default:
+		return "download"
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/godownloader/pkg/downloader"
+)
+
+func TestJSONProgress(t *testing.T) {
+	target := downloader.ProgressEvent{URL: "https://example.com/file.zip", OutputPath: "file.zip", TotalSize: 1000, Downloaded: 400}
+	with := func(event downloader.ProgressEvent) downloader.ProgressEvent {
+		event.URL, event.OutputPath = target.URL, target.OutputPath
+		event.TotalSize, event.Downloaded = target.TotalSize, target.Downloaded
+		return event
+	}
+	common := func(fields map[string]any) map[string]any {
+		line := map[string]any{
+			"url":              target.URL,
+			"output":           target.OutputPath,
+			"total_bytes":      1000.0,
+			"downloaded_bytes": 400.0,
+		}
+		for name, value := range fields {
+			line[name] = value
+		}
+		return line
+	}
+
+	tests := []struct {
+		name  string
+		event downloader.ProgressEvent
+		// want is the line without its time, or nil if none is written
+		want map[string]any
+	}{
+		{
+			name:  "start",
+			event: with(downloader.ProgressEvent{Type: downloader.EventProbing}),
+			want:  common(map[string]any{"event": "start"}),
+		},
+		{
+			name:  "progress",
+			event: with(downloader.ProgressEvent{Type: downloader.EventProgress, Speed: 200, ETA: 3 * time.Second}),
+			want:  common(map[string]any{"event": "progress", "speed_bytes_per_sec": 200.0, "eta_sec": 3.0}),
+		},
+		{
+			name:  "chunk complete",
+			event: with(downloader.ProgressEvent{Type: downloader.EventChunkComplete, ChunkID: 0}),
+			want:  common(map[string]any{"event": "chunk_complete", "chunk": 0.0}),
+		},
+		{
+			name:  "chunk retry",
+			event: with(downloader.ProgressEvent{Type: downloader.EventRetrying, ChunkID: 2, Err: &downloader.StatusError{StatusCode: 503}}),
+			want:  common(map[string]any{"event": "chunk_retry", "chunk": 2.0, "error": "unexpected status code: 503"}),
+		},
+		{
+			name:  "finished",
+			event: with(downloader.ProgressEvent{Type: downloader.EventDone, Checksum: "sha256:abc"}),
+			want:  common(map[string]any{"event": "finished", "hash": "sha256:abc"}),
+		},
+		{
+			name:  "skipped",
+			event: with(downloader.ProgressEvent{Type: downloader.EventSkipped, Checksum: "sha256:abc"}),
+			want:  common(map[string]any{"event": "skipped", "hash": "sha256:abc"}),
+		},
+		{
+			name:  "error",
+			event: with(downloader.ProgressEvent{Type: downloader.EventFailed, Err: fmt.Errorf("failed to probe: %w", &downloader.StatusError{StatusCode: 404})}),
+			want:  common(map[string]any{"event": "error", "error": "failed to probe: unexpected status code: 404", "category": "http"}),
+		},
+		{
+			name:  "unknown size",
+			event: downloader.ProgressEvent{Type: downloader.EventProgress, TotalSize: -1, Downloaded: 10},
+			want:  map[string]any{"event": "progress", "total_bytes": -1.0, "downloaded_bytes": 10.0},
+		},
+		{
+			name:  "not reported",
+			event: with(downloader.ProgressEvent{Type: downloader.EventVerifying}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			jsonProgress(&buf)(tt.event)
+
+			if tt.want == nil {
+				if buf.Len() != 0 {
+					t.Errorf("Expected no output, got %q", buf.String())
+				}
+				return
+			}
+
+			var line map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatalf("Invalid JSON line %q: %v", buf.String(), err)
+			}
+			if bytes.Count(buf.Bytes(), []byte("\n")) != 1 {
+				t.Errorf("Expected one line, got %q", buf.String())
+			}
+
+			stamp, _ := line["time"].(string)
+			if _, err := time.Parse(time.RFC3339Nano, stamp); err != nil {
+				t.Errorf("Expected an RFC 3339 time, got %v", line["time"])
+			}
+			delete(line, "time")
+
+			if !reflect.DeepEqual(line, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, line)
+			}
+		})
+	}
+}
+
+func TestErrorCategory(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{context.Canceled, "canceled"},
+		{fmt.Errorf("download failed: %w", context.DeadlineExceeded), "timeout"},
+		{fmt.Errorf("chunk 1: %w", downloader.ErrStalled), "timeout"},
+		{fmt.Errorf("chunk 1: %w", downloader.ErrTooSlow), "timeout"},
+		{fmt.Errorf("chunk 1: %w", downloader.ErrResourceChanged), "changed"},
+		{fmt.Errorf("file.zip: %w", downloader.ErrFileExists), "exists"},
+		{&downloader.ChecksumMismatchError{Algorithm: "sha256"}, "checksum"},
+		{&downloader.StatusError{StatusCode: 404}, "http"},
+		{&net.DNSError{Err: "timeout", IsTimeout: true}, "timeout"},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, "network"},
+		{&fs.PathError{Op: "open", Path: "file.zip", Err: fs.ErrPermission}, "filesystem"},
+		{errors.New("download incomplete"), "download"},
+	}
+
+	for _, tt := range tests {
+		if got := errorCategory(tt.err); got != tt.want {
+			t.Errorf("errorCategory(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestOpenProgressFD(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "progress_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	path := filepath.Join(tempDir, "events")
+	writable, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer writable.Close()
+
+	readOnly, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open file: %v", err)
+	}
+	defer readOnly.Close()
+
+	// Events reach a writable descriptor
+	progress, err := openProgressFD(int(writable.Fd()))
+	if err != nil {
+		t.Fatalf("Expected a writable descriptor to be accepted, got %v", err)
+	}
+	jsonProgress(progress)(downloader.ProgressEvent{Type: downloader.EventProbing})
+	if data, err := os.ReadFile(path); err != nil || !bytes.Contains(data, []byte(`"event":"start"`)) {
+		t.Errorf("Expected the start event in the file, got %q (%v)", data, err)
+	}
+
+	if _, err := openProgressFD(int(readOnly.Fd())); err == nil {
+		t.Error("Expected a read-only descriptor to be rejected")
+	}
+	if _, err := openProgressFD(-1); err == nil {
+		t.Error("Expected a negative descriptor to be rejected")
+	}
+}
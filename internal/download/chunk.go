@@ -2,6 +2,7 @@ package download
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
@@ -19,7 +20,12 @@ type Chunk struct {
 	Completed  bool
 	Failed     bool
 	RetryCount int
-	mu         sync.Mutex
+	// Output is the shared, preallocated output file. When set, the chunk is
+	// written at its offset in Output instead of into TempFile.
+	Output *os.File
+	// reserved counts bytes claimed by Reserve that are not yet recorded
+	reserved int64
+	mu       sync.Mutex
 }
 
 // NewChunk creates a new chunk
@@ -44,11 +50,81 @@ func (c *Chunk) UpdateProgress(bytesRead int64) {
 	defer c.mu.Unlock()
 
 	c.Downloaded += bytesRead
+	c.reserved = 0
 	if c.Downloaded >= c.Size {
 		c.Completed = true
 	}
 }
 
+// Reserve claims up to n bytes of the chunk's remaining range for writing
+// and returns how many may be written. The claim holds until UpdateProgress
+// records them, so a concurrent Split never hands those bytes to another chunk.
+func (c *Chunk) Reserve(n int64) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reserved = max(min(n, c.Size-c.Downloaded), 0)
+	return c.reserved
+}
+
+// IsComplete reports whether every byte of the chunk has been downloaded
+func (c *Chunk) IsComplete() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.Downloaded >= c.Size
+}
+
+// Split moves the second half of the chunk's remaining range into a new
+// chunk with the given ID and shrinks the chunk's End accordingly. It
+// returns nil if the remainder is smaller than twice minSize.
+func (c *Chunk) Split(id int, minSize int64) *Chunk {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Completed || c.Failed {
+		return nil
+	}
+
+	next := c.Start + c.Downloaded + c.reserved
+	remaining := c.End - next + 1
+	if remaining < 2*max(minSize, 1) {
+		return nil
+	}
+
+	mid := next + remaining/2
+	tail := NewChunk(id, c.URL, mid, c.End, filepath.Dir(c.TempFile))
+	tail.Output = c.Output
+
+	c.End = mid - 1
+	c.Size = c.End - c.Start + 1
+
+	return tail
+}
+
+// Remaining returns the number of bytes of the chunk not yet downloaded
+func (c *Chunk) Remaining() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.Size - c.Downloaded - c.reserved
+}
+
+// AssignMirror points the chunk at a URL picked from the mirrors, moving it
+// off the URL it last failed on, and returns the chosen URL
+func (c *Chunk) AssignMirror(mirrors *MirrorSet) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	exclude := ""
+	if c.RetryCount > 0 {
+		exclude = c.URL
+	}
+
+	c.URL = mirrors.Pick(exclude)
+	return c.URL
+}
+
 // MarkFailed marks the chunk as failed
 func (c *Chunk) MarkFailed() {
 	c.mu.Lock()
@@ -58,18 +134,128 @@ func (c *Chunk) MarkFailed() {
 	c.RetryCount++
 }
 
-// ResetForRetry resets the chunk for a retry attempt
+// ResetForRetry clears the chunk's failure for a retry attempt. The bytes
+// already downloaded are kept, so the retry continues where the failed
+// attempt stopped; a temp file shorter than Downloaded limits how many.
 func (c *Chunk) ResetForRetry() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// Remove the temp file if it exists
-	if _, err := os.Stat(c.TempFile); err == nil {
-		os.Remove(c.TempFile)
+	if c.Output == nil {
+		info, err := os.Stat(c.TempFile)
+		if err != nil {
+			c.Downloaded = 0
+		} else {
+			c.Downloaded = min(c.Downloaded, info.Size())
+		}
 	}
 
-	c.Downloaded = 0
+	c.reserved = 0
 	c.Failed = false
+	c.Completed = c.Downloaded >= c.Size
+}
+
+// Discard drops the bytes downloaded so far, so the chunk starts over
+func (c *Chunk) Discard() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.Downloaded = 0
+	c.reserved = 0
+	c.Completed = false
+}
+
+// ReadTail returns the last n bytes downloaded into the chunk
+func (c *Chunk) ReadTail(n int64) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n = min(n, c.Downloaded)
+	tail := make([]byte, n)
+
+	if c.Output != nil {
+		if _, err := c.Output.ReadAt(tail, c.Start+c.Downloaded-n); err != nil {
+			return nil, fmt.Errorf("failed to read output file: %w", err)
+		}
+		return tail, nil
+	}
+
+	file, err := os.Open(c.TempFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open temp file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.ReadAt(tail, c.Downloaded-n); err != nil {
+		return nil, fmt.Errorf("failed to read temp file: %w", err)
+	}
+	return tail, nil
+}
+
+// chunkFileWriter writes into a shared output file without closing it
+type chunkFileWriter struct {
+	*io.OffsetWriter
+}
+
+// Close is a no-op; the shared output file is closed by its owner
+func (chunkFileWriter) Close() error {
+	return nil
+}
+
+// OpenWriter returns a writer positioned after the bytes already downloaded.
+// A temp file is truncated to that point so stale trailing bytes are dropped.
+func (c *Chunk) OpenWriter() (io.WriteCloser, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.Output != nil {
+		return chunkFileWriter{io.NewOffsetWriter(c.Output, c.Start+c.Downloaded)}, nil
+	}
+
+	file, err := os.OpenFile(c.TempFile, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	if err := file.Truncate(c.Downloaded); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to truncate temp file: %w", err)
+	}
+	if _, err := file.Seek(c.Downloaded, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to seek temp file: %w", err)
+	}
+
+	return file, nil
+}
+
+// Record returns a snapshot of the chunk suitable for persisting
+func (c *Chunk) Record() ChunkRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return ChunkRecord{
+		ID:         c.ID,
+		Start:      c.Start,
+		End:        c.End,
+		Downloaded: c.Downloaded,
+	}
+}
+
+// State returns a snapshot of the chunk's progress for progress events
+func (c *Chunk) State() ChunkState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return ChunkState{
+		ID:         c.ID,
+		Start:      c.Start,
+		End:        c.End,
+		Downloaded: c.Downloaded,
+		Completed:  c.Completed,
+		Failed:     c.Failed,
+		RetryCount: c.RetryCount,
+	}
 }
 
 // GetProgress returns the current progress as a percentage
@@ -109,9 +109,8 @@ func TestResetForRetry(t *testing.T) {
 	// Create chunk
 	chunk := NewChunk(1, "https://example.com/test.zip", 0, 1000, tempDir)
 
-	// Create temp file
-	_, err = os.Create(chunk.TempFile)
-	if err != nil {
+	// Only 300 of the 500 bytes counted made it to the temp file
+	if err := os.WriteFile(chunk.TempFile, make([]byte, 300), 0644); err != nil {
 		t.Fatalf("Failed to create temp file: %v", err)
 	}
 
@@ -122,23 +121,31 @@ func TestResetForRetry(t *testing.T) {
 	// Reset
 	chunk.ResetForRetry()
 
-	if chunk.Downloaded != 0 {
-		t.Errorf("Expected Downloaded to be 0, got %d", chunk.Downloaded)
+	if chunk.Downloaded != 300 {
+		t.Errorf("Expected Downloaded to be 300, got %d", chunk.Downloaded)
 	}
 
 	if chunk.Failed {
 		t.Error("Expected Failed to be false")
 	}
 
-	// Verify file was removed
-	_, err = os.Stat(chunk.TempFile)
-	if !os.IsNotExist(err) {
-		t.Error("Expected temp file to be removed")
+	// Verify the partial data was kept
+	if _, err := os.Stat(chunk.TempFile); err != nil {
+		t.Errorf("Expected temp file to be kept: %v", err)
+	}
+
+	// Without a temp file the chunk starts over
+	os.Remove(chunk.TempFile)
+	chunk.Failed = true
+	chunk.ResetForRetry()
+
+	if chunk.Downloaded != 0 {
+		t.Errorf("Expected Downloaded to be 0, got %d", chunk.Downloaded)
 	}
 }
 
 func TestGetProgress(t *testing.T) {
-	chunk := NewChunk(1, "https://example.com/test.zip", 0, 1000, "/tmp")
+	chunk := NewChunk(1, "https://example.com/test.zip", 0, 999, "/tmp")
 
 	// Test 0 progress
 	progress := chunk.GetProgress()
@@ -262,3 +269,58 @@ func TestGetTempFilePaths(t *testing.T) {
 		t.Errorf("Expected second path to be %s, got %s", chunk2.TempFile, paths[1])
 	}
 }
+
+func TestSplit(t *testing.T) {
+	chunk := NewChunk(0, "https://example.com/test.zip", 0, 999, "/tmp/parts")
+	chunk.Downloaded = 200
+
+	tail := chunk.Split(5, 100)
+	if tail == nil {
+		t.Fatal("Expected chunk to be split")
+	}
+
+	// The remaining 800 bytes are divided evenly
+	if chunk.End != 599 || chunk.Size != 600 {
+		t.Errorf("Expected chunk to end at 599 with size 600, got End=%d Size=%d", chunk.End, chunk.Size)
+	}
+
+	if tail.ID != 5 || tail.Start != 600 || tail.End != 999 || tail.Size != 400 {
+		t.Errorf("Unexpected tail chunk: ID=%d Start=%d End=%d Size=%d", tail.ID, tail.Start, tail.End, tail.Size)
+	}
+
+	if tail.TempFile != filepath.Join("/tmp/parts", "chunk_5") {
+		t.Errorf("Expected tail temp file in the same directory, got %s", tail.TempFile)
+	}
+
+	// Too little left to split
+	chunk.Downloaded = 450
+	if chunk.Split(6, 100) != nil {
+		t.Error("Expected no split below twice the minimum size")
+	}
+
+	// Completed chunks are never split
+	tail.Completed = true
+	if tail.Split(7, 1) != nil {
+		t.Error("Expected no split of a completed chunk")
+	}
+}
+
+func TestReserve(t *testing.T) {
+	chunk := NewChunk(0, "https://example.com/test.zip", 0, 999, "/tmp")
+	chunk.Downloaded = 900
+
+	if n := chunk.Reserve(32); n != 32 {
+		t.Errorf("Expected to reserve 32 bytes, got %d", n)
+	}
+
+	// Reserved bytes are not split off
+	if remaining := chunk.Remaining(); remaining != 68 {
+		t.Errorf("Expected 68 bytes remaining, got %d", remaining)
+	}
+	chunk.UpdateProgress(32)
+
+	// Reservations are clamped to the end of the chunk
+	if n := chunk.Reserve(1024); n != 68 {
+		t.Errorf("Expected to reserve 68 bytes, got %d", n)
+	}
+}
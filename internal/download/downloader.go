@@ -1,16 +1,28 @@
 package download
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/godownloader/internal/utils"
 )
 
+// stateSaveInterval is how often the resume state is written during a download
+const stateSaveInterval = time.Second
+
 // Downloader represents the main downloader
 type Downloader struct {
 	URL            string
@@ -20,11 +32,79 @@ type Downloader struct {
 	TempDir        string
 	ContentLength  int64
 	SupportsRanges bool
+	ETag           string
+	LastModified   string
+	Filename       string
+	ContentType    string
 	Chunks         []*Chunk
 	Progress       *Progress
 	Client         *http.Client
 	MaxRetries     int
 	Verbose        bool
+	Preallocate    bool
+	Checksum       *utils.Checksum
+	ActualChecksum string
+	MinSplitSize   int64
+	Mirrors        []string
+
+	// OutputDir holds the file when its name is resolved from the response
+	// because no output path was given
+	OutputDir   string
+	resolveName bool
+
+	// OnExists decides what happens to a file already at the output path;
+	// Skipped is set when it was kept instead of downloading
+	OnExists       ExistsPolicy
+	Skipped        bool
+	resumeExisting bool
+
+	// PathLocks, if set, makes downloads sharing it take turns writing to
+	// the same output path
+	PathLocks   *PathLocks
+	pathUnlocks []func()
+
+	// PartPath, if set, replaces <output>.part as the file written until the
+	// download completes. It must be on the same filesystem as the output.
+	PartPath string
+
+	// PreserveModTime sets the modification time of the finished file to
+	// the server's Last-Modified
+	PreserveModTime bool
+
+	// RateLimiter throttles this download; SharedRateLimiter, if set, is
+	// also shared with other downloads
+	RateLimiter       *utils.RateLimiter
+	SharedRateLimiter *utils.RateLimiter
+
+	// RetryPolicy controls retries of each request; nil means the default
+	RetryPolicy *utils.RetryPolicy
+
+	// Request adds headers, credentials and cookies to every request
+	Request *utils.RequestOptions
+
+	// ResolvedURL is where the probe found the file after following
+	// Redirects; chunks are requested from it directly. Credentials are
+	// only sent to the hosts of URL and Mirrors unless TrustRedirects is set.
+	ResolvedURL    string
+	Redirects      []string
+	TrustRedirects bool
+	request        *utils.RequestOptions
+
+	// Timeouts bound connecting and abort responses that stall or arrive
+	// too slowly
+	Timeouts utils.Timeouts
+
+	// clientOptions configure Client when set with SetProxy, SetTLS or
+	// SetTimeouts
+	clientOptions utils.ClientOptions
+
+	// listeners receive progress events; console renders them when Verbose
+	listeners []ProgressFunc
+	console   ConsoleRenderer
+	eventMu   sync.Mutex
+
+	// mu guards Chunks, which grows when chunks are split during a download
+	mu sync.Mutex
 }
 
 // NewDownloader creates a new downloader
@@ -33,145 +113,520 @@ func NewDownloader(url, outputPath string, numThreads int) *Downloader {
 		numThreads = runtime.NumCPU()
 	}
 
-	if outputPath == "" {
-		outputPath = filepath.Base(url)
+	// Without an output path the name is taken from the URL until the
+	// response suggests a better one
+	resolveName := outputPath == ""
+	if resolveName {
+		outputPath = utils.ResolveFilename("", url, "")
 	}
 
 	return &Downloader{
-		URL:        url,
-		OutputPath: outputPath,
-		NumThreads: numThreads,
-		TempDir:    "",
-		MaxRetries: 3,
-		Verbose:    true,
-		Client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		URL:          url,
+		OutputPath:   outputPath,
+		NumThreads:   numThreads,
+		TempDir:      "",
+		MaxRetries:   3,
+		Verbose:      true,
+		MinSplitSize: DefaultMinSplitSize,
+		RateLimiter:  utils.NewRateLimiter(0),
+		resolveName:  resolveName,
+		OnExists:     ExistsOverwrite,
+		Client:       utils.NewClient(nil),
 	}
 }
 
 // Start begins the download process
 func (d *Downloader) Start() error {
+	return d.StartContext(context.Background())
+}
+
+// StartContext begins the download process, aborting it when ctx is done.
+// A canceled multi-threaded download keeps its resume state, and the
+// context's error is returned unwrapped.
+func (d *Downloader) StartContext(ctx context.Context) error {
+	d.mu.Lock()
+	d.Progress = nil
+	d.mu.Unlock()
+	d.Skipped = false
+	d.resumeExisting = false
+
+	err := d.start(ctx)
+	if err != nil {
+		d.emit(ProgressEvent{Type: EventFailed, Err: err})
+		return err
+	}
+
+	done := ProgressEvent{Type: EventDone}
+	if d.Skipped {
+		done.Type = EventSkipped
+		done.Downloaded = d.ContentLength
+	}
+	if d.Checksum != nil {
+		done.Checksum = d.Checksum.Algorithm + ":" + d.ActualChecksum
+	}
+	d.emit(done)
+	return nil
+}
+
+// start probes the file and downloads it
+func (d *Downloader) start(ctx context.Context) error {
 	if d.Verbose {
 		fmt.Printf("Starting download of %s with %d threads\n", d.URL, d.NumThreads)
 	}
+	d.emit(ProgressEvent{Type: EventProbing})
+	d.request = d.requestOptions()
+	defer d.unlockPaths()
 
-	// Create temporary directory
-	tempDir, err := utils.CreateTempDir("downloader")
+	// Find out the size, range support and version of the file
+	probe, err := utils.Probe(ctx, d.Client, d.URL, d.retryPolicy(), d.request)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %w", err)
+		return fmt.Errorf("failed to probe %s: %w", d.URL, err)
 	}
-	d.TempDir = tempDir
-	defer utils.CleanupTempDir(tempDir)
 
-	// Get content length and check if server supports range requests
-	contentLength, err := utils.GetContentLength(d.URL)
-	if err != nil {
-		return fmt.Errorf("failed to get content length: %w", err)
+	d.ResolvedURL = probe.URL
+	d.Redirects = probe.Redirects
+	if d.Verbose && len(d.Redirects) > 0 {
+		fmt.Printf("Redirected to %s\n", d.ResolvedURL)
 	}
 
-	supportsRanges, err := utils.CheckRangeSupport(d.URL)
-	if err != nil {
-		return fmt.Errorf("failed to check range support: %w", err)
+	d.ContentLength = probe.ContentLength
+	d.SupportsRanges = probe.SupportsRanges
+	d.ETag = probe.ETag
+	d.LastModified = probe.LastModified
+	d.Filename = probe.Filename
+	d.ContentType = probe.ContentType
+
+	if d.resolveName {
+		d.OutputPath = filepath.Join(d.OutputDir, utils.ResolveFilename(d.Filename, d.URL, d.ContentType))
 	}
 
-	d.ContentLength = contentLength
-	d.SupportsRanges = supportsRanges
+	// Wait for another download writing to the same path to finish first
+	if err := d.lockPath(ctx, d.OutputPath); err != nil {
+		return err
+	}
 
-	// If the server doesn't support range requests or if using single thread,
-	// fall back to single-threaded download
-	if !supportsRanges || d.NumThreads == 1 {
-		return d.downloadSingleThreaded()
+	// Decide what to do about a file already at the output path
+	if err := d.checkExisting(); err != nil {
+		return err
+	}
+	if d.Skipped {
+		return nil
 	}
 
-	return d.downloadMultiThreaded()
+	// If the server doesn't support range requests, doesn't report the size,
+	// the file is empty or if using single thread, fall back to
+	// single-threaded download
+	// An existing partial file is continued the same way
+	if !d.SupportsRanges || d.ContentLength <= 0 || d.NumThreads == 1 || d.resumeExisting {
+		return d.downloadSingleThreaded(ctx)
+	}
+
+	return d.downloadMultiThreaded(ctx)
 }
 
 // downloadMultiThreaded handles multi-threaded download
-func (d *Downloader) downloadMultiThreaded() error {
+func (d *Downloader) downloadMultiThreaded(ctx context.Context) error {
 	if d.Verbose {
 		fmt.Println("Using multi-threaded download")
 	}
 
-	// Calculate chunks
-	chunks, err := CalculateChunks(d.URL, d.ContentLength, d.NumThreads, d.TempDir)
+	// Resume from a previous run or calculate fresh chunks
+	chunks, err := d.prepareChunks()
 	if err != nil {
-		return fmt.Errorf("failed to calculate chunks: %w", err)
+		return err
+	}
+	d.setChunks(chunks)
+
+	// Chunks share the preallocated output file, if any
+	output := chunks[0].Output
+	if output != nil {
+		defer output.Close()
+	}
+
+	// Hash the data when a checksum is requested: chunks in their own temp
+	// files while they are merged, a preallocated file while it downloads
+	var h hash.Hash
+	var hasher *prefixHasher
+	if d.Checksum != nil {
+		h, err = d.Checksum.NewHash()
+		if err != nil {
+			return err
+		}
+
+		if output != nil {
+			hasher = newPrefixHasher(h, output)
+			hasher.Track(100*time.Millisecond, d.snapshotChunks)
+			defer hasher.Stop()
+		}
 	}
-	d.Chunks = chunks
 
 	// Create progress tracker
 	progress := NewProgress(d.ContentLength, chunks)
-	d.Progress = progress
+	stopProgress := d.trackProgress(progress)
+	defer stopProgress()
 
-	// Start progress tracking
-	stopProgressChan := make(chan struct{})
-	go progress.StartTracking(100*time.Millisecond, stopProgressChan)
+	// Keep the state file current so an interrupted run can resume
+	stopSavingState := d.startStateSaver(stateSaveInterval)
+	defer stopSavingState()
 
-	// Start worker pool
-	results, err := StartWorkerPool(d.NumThreads, chunks)
+	// Start worker pool, splitting slow chunks for idle workers
+	pool := NewWorkerPool(d.NumThreads, chunks)
+	pool.MinSplitSize = d.MinSplitSize
+	pool.OnSplit = d.addChunk
+	pool.MaxRetries = d.MaxRetries
+	pool.OnRetry = func(chunk *Chunk, err error) {
+		d.emit(ProgressEvent{Type: EventRetrying, ChunkID: chunk.ID, Err: err})
+	}
+	pool.Limiters = d.limiters()
+	pool.RetryPolicy = d.retryPolicy()
+	pool.Request = d.request
+	pool.Client = d.Client
+	pool.Timeouts = d.Timeouts
+	pool.IfRange = d.ifRange()
+	pool.ETag = d.ETag
+	if len(d.Mirrors) > 0 {
+		pool.Mirrors = d.newMirrorSet(ctx)
+	}
+	results, err := pool.Run(ctx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errors.Is(err, ErrResourceChanged) {
+		stopSavingState()
+		return d.discard(err)
+	}
 	if err != nil {
-		close(stopProgressChan)
 		return fmt.Errorf("download failed: %w", err)
 	}
 
-	// Check results
-	var hasFailures bool
-	for _, result := range results {
-		if result.Error != nil {
-			hasFailures = true
+	// Every chunk was retried up to MaxRetries times while the pool ran
+	if err := ResultErrors(results); err != nil {
+		return fmt.Errorf("download failed: %w", err)
+	}
+
+	// Verify all chunks are complete
+	if !ValidateChunks(d.snapshotChunks()) {
+		return fmt.Errorf("download incomplete, some chunks failed")
+	}
+
+	stopProgress()
+	stopSavingState()
+
+	// Flush the data to disk before it is verified and renamed
+	if output != nil {
+		if err := output.Sync(); err != nil {
+			return fmt.Errorf("failed to sync output: %w", err)
+		}
+	}
+
+	// Merge chunks, unless they were written straight into the part file
+	var digest string
+	if !d.Preallocate {
+		d.emit(ProgressEvent{Type: EventMerging})
+
+		err = d.mergeChunksTo(d.partPath(), h)
+		if err != nil {
+			return fmt.Errorf("failed to merge chunks: %w", err)
+		}
+		if h != nil {
+			d.emit(ProgressEvent{Type: EventVerifying})
+			digest = hex.EncodeToString(h.Sum(nil))
+		}
+	} else {
+		if hasher != nil {
+			d.emit(ProgressEvent{Type: EventVerifying})
+
+			digest, err = hasher.Finish(d.ContentLength)
+			if err != nil {
+				return err
+			}
+		}
+		output.Close()
+	}
+
+	// Verify the data before it is given the output name
+	var verifyErr error
+	if d.Checksum != nil {
+		d.ActualChecksum = digest
+		verifyErr = d.Checksum.Verify(digest)
+	}
+
+	// The chunks are no longer needed once the part file is complete
+	d.setChunks(nil)
+	if err := RemoveState(d.OutputPath); err != nil {
+		return fmt.Errorf("failed to remove resume state: %w", err)
+	}
+
+	if verifyErr != nil {
+		return d.quarantine(verifyErr)
+	}
+
+	return d.finalize()
+}
+
+// ifRange returns the validator sent as If-Range with chunk requests. Weak
+// ETags can't be used with If-Range, and mirrors are only known to share
+// the ETag, not the modification time.
+func (d *Downloader) ifRange() string {
+	if d.ETag != "" && !strings.HasPrefix(d.ETag, "W/") {
+		return d.ETag
+	}
+	if len(d.Mirrors) == 0 {
+		return d.LastModified
+	}
+	return ""
+}
+
+// discard removes the partial download after the remote file changed, so
+// the next run starts over instead of resuming from the old version
+func (d *Downloader) discard(cause error) error {
+	if output := d.snapshotChunks()[0].Output; output != nil {
+		output.Close()
+		os.Remove(d.partPath())
+	}
+
+	d.setChunks(nil)
+	if err := RemoveState(d.OutputPath); err != nil {
+		return fmt.Errorf("failed to remove resume state: %w", err)
+	}
+
+	return fmt.Errorf("download aborted: %w", cause)
+}
+
+// newMirrorSet returns a set of the primary URL and every mirror that
+// serves the same file, probing each mirror for its size, range support and
+// ETag
+func (d *Downloader) newMirrorSet(ctx context.Context) *MirrorSet {
+	seen := []string{d.URL}
+	urls := []string{d.ResolvedURL}
+	for _, mirror := range d.Mirrors {
+		if slices.Contains(seen, mirror) {
+			continue
+		}
+		seen = append(seen, mirror)
+
+		resolved, err := d.checkMirror(ctx, mirror)
+		if err != nil {
 			if d.Verbose {
-				fmt.Printf("Chunk %d failed: %v\n", result.Chunk.ID, result.Error)
+				fmt.Printf("Skipping mirror %s: %v\n", mirror, err)
 			}
+			continue
+		}
+		urls = append(urls, resolved)
+	}
+
+	if d.Verbose && len(urls) > 1 {
+		fmt.Printf("Downloading from %d mirrors\n", len(urls))
+	}
+
+	return NewMirrorSet(urls)
+}
+
+// checkMirror verifies that a mirror serves the same file as the primary
+// URL and returns the URL it redirects to
+func (d *Downloader) checkMirror(ctx context.Context, url string) (string, error) {
+	probe, err := utils.Probe(ctx, d.Client, url, d.retryPolicy(), d.request)
+	if err != nil {
+		return "", err
+	}
+	if probe.ContentLength != d.ContentLength {
+		return "", fmt.Errorf("size %d differs from %d", probe.ContentLength, d.ContentLength)
+	}
+	if !probe.SupportsRanges {
+		return "", errors.New("range requests not supported")
+	}
+	if probe.ETag != d.ETag {
+		return "", fmt.Errorf("ETag %s differs from %s", probe.ETag, d.ETag)
+	}
+
+	return probe.URL, nil
+}
+
+// requestOptions returns the request options of the download, limiting
+// credentials to the hosts of URL and Mirrors unless redirects are trusted
+func (d *Downloader) requestOptions() *utils.RequestOptions {
+	var opts utils.RequestOptions
+	if d.Request != nil {
+		opts = *d.Request
+	}
+	if d.TrustRedirects {
+		return &opts
+	}
+
+	opts.AuthHosts = nil
+	for _, rawURL := range append([]string{d.URL}, d.Mirrors...) {
+		if u, err := url.Parse(rawURL); err == nil {
+			opts.AuthHosts = append(opts.AuthHosts, u.Host)
 		}
 	}
+	return &opts
+}
+
+// prepareChunks restores chunks from a matching state file, or discards any
+// stale state and divides the file into new chunks
+func (d *Downloader) prepareChunks() ([]*Chunk, error) {
+	d.TempDir = PartsDir(d.OutputPath)
 
-	// Retry failed chunks
-	if hasFailures {
+	if chunks := d.restoreChunks(); chunks != nil {
 		if d.Verbose {
-			fmt.Println("Retrying failed chunks...")
+			fmt.Printf("Resuming download from %s\n", StatePath(d.OutputPath))
 		}
+		return chunks, nil
+	}
 
-		err = RetryFailedChunks(d.Chunks, d.MaxRetries)
-		if err != nil {
-			close(stopProgressChan)
-			return fmt.Errorf("retry failed: %w", err)
+	// Start over, since the remote file changed or the state is unusable
+	if err := RemoveState(d.OutputPath); err != nil {
+		return nil, fmt.Errorf("failed to remove stale resume state: %w", err)
+	}
+
+	chunks, err := CalculateChunks(d.ResolvedURL, d.ContentLength, d.NumThreads, d.TempDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate chunks: %w", err)
+	}
+
+	if !d.Preallocate {
+		if err := os.MkdirAll(d.TempDir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create chunk directory: %w", err)
 		}
+		return chunks, nil
 	}
 
-	// Verify all chunks are complete
-	if !ValidateChunks(d.Chunks) {
-		close(stopProgressChan)
-		return fmt.Errorf("download incomplete, some chunks failed")
+	output, err := utils.CreateFile(d.partPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	if err := output.Truncate(d.ContentLength); err != nil {
+		output.Close()
+		return nil, fmt.Errorf("failed to preallocate output file: %w", err)
 	}
 
-	// Signal progress tracking to stop
-	close(stopProgressChan)
+	for _, chunk := range chunks {
+		chunk.Output = output
+	}
 
-	// Print summary if verbose
-	if d.Verbose {
-		d.Progress.PrintSummary()
+	return chunks, nil
+}
+
+// restoreChunks returns the chunks of a previous run of the same download,
+// or nil if there is nothing usable to resume
+func (d *Downloader) restoreChunks() []*Chunk {
+	state, err := LoadState(StatePath(d.OutputPath))
+	if err != nil || state.Preallocated != d.Preallocate ||
+		!state.Matches(d.URL, d.ContentLength, d.ETag, d.LastModified) {
+		return nil
 	}
 
-	// Merge chunks
-	if d.Verbose {
-		fmt.Println("Merging chunks...")
+	chunks, err := state.RestoreChunks(d.TempDir)
+	if err != nil {
+		return nil
+	}
+	for _, chunk := range chunks {
+		chunk.URL = d.ResolvedURL
 	}
 
-	err = d.mergeChunks()
+	if !d.Preallocate {
+		return chunks
+	}
+
+	// The partial output must still be the file that was preallocated
+	output, err := os.OpenFile(d.partPath(), os.O_RDWR, 0644)
 	if err != nil {
-		return fmt.Errorf("failed to merge chunks: %w", err)
+		return nil
+	}
+	if info, err := output.Stat(); err != nil || info.Size() != d.ContentLength {
+		output.Close()
+		return nil
 	}
 
-	if d.Verbose {
-		fmt.Printf("Download completed: %s\n", d.OutputPath)
+	for _, chunk := range chunks {
+		chunk.Output = output
 	}
 
-	return nil
+	return chunks
+}
+
+// setChunks replaces the chunks of the download
+func (d *Downloader) setChunks(chunks []*Chunk) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.Chunks = chunks
+}
+
+// snapshotChunks returns a copy of the chunks, ordered by Start
+func (d *Downloader) snapshotChunks() []*Chunk {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return slices.Clone(d.Chunks)
+}
+
+// addChunk records a chunk split off an in-flight chunk
+func (d *Downloader) addChunk(chunk *Chunk) {
+	d.mu.Lock()
+	i := len(d.Chunks)
+	for i > 0 && d.Chunks[i-1].Start > chunk.Start {
+		i--
+	}
+	d.Chunks = slices.Insert(d.Chunks, i, chunk)
+	progress := d.Progress
+	d.mu.Unlock()
+
+	if progress != nil {
+		progress.AddChunk(chunk)
+	}
+}
+
+// saveState writes the resume state for the current chunks, if any
+func (d *Downloader) saveState() error {
+	chunks := d.snapshotChunks()
+	if chunks == nil {
+		return nil
+	}
+
+	state := NewState(d.URL, d.ContentLength, d.ETag, d.LastModified, chunks)
+	state.Preallocated = d.Preallocate
+	return state.Save(StatePath(d.OutputPath))
+}
+
+// startStateSaver saves the resume state periodically in the background. The
+// returned function stops the saver, writes the state a final time and is
+// safe to call more than once.
+func (d *Downloader) startStateSaver(interval time.Duration) func() {
+	stopChan := make(chan struct{})
+	doneChan := make(chan struct{})
+
+	go func() {
+		defer close(doneChan)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				d.saveState()
+			case <-stopChan:
+				d.saveState()
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stopChan)
+			<-doneChan
+		})
+	}
 }
 
 // downloadSingleThreaded downloads the file using a single thread
-func (d *Downloader) downloadSingleThreaded() error {
+func (d *Downloader) downloadSingleThreaded(ctx context.Context) error {
 	if d.Verbose {
 		if !d.SupportsRanges {
 			fmt.Println("Server doesn't support range requests. Using single-threaded download.")
@@ -180,86 +635,235 @@ func (d *Downloader) downloadSingleThreaded() error {
 		}
 	}
 
+	// Continue an interrupted run of the same file from where it stopped
+	offset := d.resumeOffset()
+
 	// Create the request
-	req, err := utils.CreateHTTPRequest("GET", d.URL, -1, -1)
+	rangeStart := int64(-1)
+	if offset > 0 {
+		rangeStart = offset
+	}
+	reqCtx, watchdog := d.Timeouts.Watch(ctx)
+	defer watchdog.Stop()
+	req, err := utils.CreateHTTPRequestContext(reqCtx, "GET", d.ResolvedURL, rangeStart, -1)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
+	d.request.Apply(req)
+	if ifRange := d.ifRange(); offset > 0 && ifRange != "" {
+		req.Header.Set("If-Range", ifRange)
+	}
 
 	// Send the request
-	resp, err := utils.DoRequestWithRetry(d.Client, req)
+	resp, err := d.retryPolicy().Do(d.request.Client(d.Client), req)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// Create the output file
-	file, err := utils.CreateFile(d.OutputPath)
+	// A full response means the file changed or the range was ignored
+	if resp.StatusCode != http.StatusPartialContent {
+		offset = 0
+	}
+
+	// Open the output file, keeping the bytes of the interrupted run
+	file, err := d.openStreamOutput(offset)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return err
 	}
 	defer file.Close()
 
-	// Create progress tracker for single-threaded download
-	var totalSize int64 = resp.ContentLength
-	if totalSize <= 0 {
-		totalSize = d.ContentLength
+	if offset > 0 && d.Verbose {
+		fmt.Printf("Resuming download at byte %d\n", offset)
+	}
+
+	// The size may only be known from the response; -1 means unknown
+	totalSize := d.ContentLength
+	if totalSize < 0 && resp.StatusCode == http.StatusOK {
+		totalSize = resp.ContentLength
 	}
 
 	progress := NewProgress(totalSize, nil)
-	d.Progress = progress
+	progress.ResumedBytes = offset
+	progress.Downloaded = offset
+	stopProgress := d.trackProgress(progress)
+	defer stopProgress()
 
-	stopProgressChan := make(chan struct{})
-	go progress.StartTracking(100*time.Millisecond, stopProgressChan)
+	// Hash the data as it is written when a checksum is requested, starting
+	// with the bytes kept from the interrupted run
+	var writer io.Writer = file
+	var h hash.Hash
+	if d.Checksum != nil {
+		h, err = d.Checksum.NewHash()
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(h, io.NewSectionReader(file, 0, offset)); err != nil {
+			return fmt.Errorf("failed to hash resumed data: %w", err)
+		}
+		writer = io.MultiWriter(file, h)
+	}
 
 	// Download the file
 	buffer := make([]byte, 32*1024) // 32KB buffer
-	var downloaded int64
+	downloaded := offset
+	limiters := d.limiters()
+	watchdog.Start()
 
 	for {
 		n, err := resp.Body.Read(buffer)
 		if n > 0 {
-			_, writeErr := file.Write(buffer[:n])
+			watchdog.Received(n)
+
+			watchdog.Pause()
+			if err := waitLimiters(ctx, limiters, n); err != nil {
+				return err
+			}
+			watchdog.Resume()
+
+			_, writeErr := writer.Write(buffer[:n])
 			if writeErr != nil {
-				close(stopProgressChan)
 				return fmt.Errorf("failed to write to file: %w", writeErr)
 			}
 
 			downloaded += int64(n)
-			progress.Downloaded = downloaded
+			progress.SetDownloaded(downloaded)
 		}
 
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			close(stopProgressChan)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if cause := watchdog.Err(); cause != nil {
+				return fmt.Errorf("received %d bytes: %w", downloaded, cause)
+			}
 			return fmt.Errorf("failed to read response: %w", err)
 		}
 	}
 
-	// Signal progress tracking to stop
-	close(stopProgressChan)
+	stopProgress()
 
-	// Print summary if verbose
-	if d.Verbose {
-		progress.PrintSummary()
+	// Keep the state so a truncated download can be resumed
+	if totalSize >= 0 && downloaded != totalSize {
+		return fmt.Errorf("received %d of %d bytes: %w", downloaded, totalSize, io.ErrUnexpectedEOF)
 	}
 
-	if d.Verbose {
-		fmt.Printf("Download completed: %s\n", d.OutputPath)
+	// Flush the data to disk before it is verified and renamed
+	if err := syncAndClose(file); err != nil {
+		return err
+	}
+	if err := RemoveState(d.OutputPath); err != nil {
+		return fmt.Errorf("failed to remove resume state: %w", err)
 	}
 
-	return nil
+	// Verify the data, moving the file aside if it doesn't match
+	if h != nil {
+		d.ActualChecksum = hex.EncodeToString(h.Sum(nil))
+		if verifyErr := d.Checksum.Verify(d.ActualChecksum); verifyErr != nil {
+			return d.quarantine(verifyErr)
+		}
+	}
+
+	return d.finalize()
+}
+
+// resumeOffset returns how many bytes of an interrupted single-threaded
+// download of the same file can be kept, or 0 to start over. Resuming needs
+// range support and a validator to send as If-Range.
+func (d *Downloader) resumeOffset() int64 {
+	if !d.SupportsRanges {
+		return 0
+	}
+
+	// An existing file taken over by ExistsResume has no state to check
+	if !d.resumeExisting {
+		if d.ifRange() == "" {
+			return 0
+		}
+
+		state, err := LoadState(StatePath(d.OutputPath))
+		if err != nil || len(state.Chunks) > 0 || !state.Matches(d.URL, d.ContentLength, d.ETag, d.LastModified) {
+			return 0
+		}
+	}
+
+	// The part file is authoritative for how much was written
+	info, err := os.Stat(d.partPath())
+	if err != nil || (d.ContentLength >= 0 && info.Size() >= d.ContentLength) {
+		return 0
+	}
+	return info.Size()
+}
+
+// openStreamOutput opens the part file of a single-threaded download
+// positioned at offset, creating it afresh if offset is 0. A fresh download
+// that can be resumed records its state first.
+func (d *Downloader) openStreamOutput(offset int64) (*os.File, error) {
+	if offset > 0 {
+		file, err := os.OpenFile(d.partPath(), os.O_RDWR, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open output file: %w", err)
+		}
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to seek output file: %w", err)
+		}
+		return file, nil
+	}
+
+	// Drop the state of any earlier run, which no longer applies
+	if err := RemoveState(d.OutputPath); err != nil {
+		return nil, fmt.Errorf("failed to remove stale resume state: %w", err)
+	}
+
+	file, err := utils.CreateFile(d.partPath())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+
+	if d.SupportsRanges && d.ifRange() != "" {
+		state := NewState(d.URL, d.ContentLength, d.ETag, d.LastModified, nil)
+		if err := state.Save(StatePath(d.OutputPath)); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
+// quarantined records where a file that failed verification was moved
+func quarantined(err error, path string) error {
+	var mismatch *utils.ChecksumMismatchError
+	if errors.As(err, &mismatch) {
+		mismatch.Path = path
+	}
+	return err
 }
 
 // mergeChunks combines all downloaded chunks into the final file
 func (d *Downloader) mergeChunks() error {
+	return d.mergeChunksTo(d.OutputPath, nil)
+}
+
+// mergeChunksTo combines all downloaded chunks into the file at path,
+// writing the merged data to h as well if it is not nil
+func (d *Downloader) mergeChunksTo(path string, h hash.Hash) error {
 	// Get paths to all chunk files
-	paths := GetTempFilePaths(d.Chunks)
+	paths := GetTempFilePaths(d.snapshotChunks())
 
 	// Merge files
-	err := utils.MergeFiles(d.OutputPath, paths)
+	var tee io.Writer
+	if h != nil {
+		tee = h
+	}
+	err := utils.MergeFilesTee(path, paths, tee)
 	if err != nil {
 		return fmt.Errorf("failed to merge chunks: %w", err)
 	}
@@ -276,3 +880,232 @@ func (d *Downloader) SetVerbose(verbose bool) {
 func (d *Downloader) SetMaxRetries(maxRetries int) {
 	d.MaxRetries = maxRetries
 }
+
+// SetChecksum sets the expected checksum of the downloaded file
+func (d *Downloader) SetChecksum(checksum *utils.Checksum) {
+	d.Checksum = checksum
+}
+
+// SetMinSplitSize sets the smallest range split off a slow chunk for an idle
+// worker. Zero or less disables splitting.
+func (d *Downloader) SetMinSplitSize(size int64) {
+	d.MinSplitSize = size
+}
+
+// trackProgress makes progress the download's tracker and sends periodic
+// progress events until the returned function is called. The function waits
+// for the final update and is safe to call more than once.
+func (d *Downloader) trackProgress(progress *Progress) func() {
+	// Chunks restored complete from a previous run are not reported
+	completed := make(map[int]bool)
+	for _, chunk := range progress.Chunks {
+		if state := chunk.State(); state.Completed {
+			completed[state.ID] = true
+		}
+	}
+
+	progress.OnUpdate = func() {
+		for _, chunk := range progress.Snapshot(EventProgress).Chunks {
+			if chunk.Completed && !completed[chunk.ID] {
+				completed[chunk.ID] = true
+				d.emit(ProgressEvent{Type: EventChunkComplete, ChunkID: chunk.ID})
+			}
+		}
+		d.emit(ProgressEvent{Type: EventProgress})
+	}
+
+	d.mu.Lock()
+	d.Progress = progress
+	d.mu.Unlock()
+	d.emit(ProgressEvent{Type: EventDownloading})
+
+	stopChan := make(chan struct{})
+	doneChan := make(chan struct{})
+	go func() {
+		defer close(doneChan)
+		progress.StartTracking(100*time.Millisecond, stopChan)
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stopChan)
+			<-doneChan
+		})
+	}
+}
+
+// emit sends an event to every listener, filling in the download's current
+// progress. Events are delivered one at a time.
+func (d *Downloader) emit(event ProgressEvent) {
+	d.mu.Lock()
+	progress := d.Progress
+	d.mu.Unlock()
+
+	if progress != nil {
+		snapshot := progress.Snapshot(event.Type)
+		snapshot.ChunkID, snapshot.Err, snapshot.Checksum = event.ChunkID, event.Err, event.Checksum
+		event = snapshot
+	} else {
+		event.TotalSize = d.ContentLength
+	}
+	event.URL = d.URL
+	event.OutputPath = d.OutputPath
+
+	d.eventMu.Lock()
+	defer d.eventMu.Unlock()
+
+	if d.Verbose {
+		d.console.Render(event)
+	}
+	for _, listener := range d.listeners {
+		listener(event)
+	}
+}
+
+// Subscribe registers fn to receive progress and lifecycle events. It must
+// be called before the download starts.
+func (d *Downloader) Subscribe(fn ProgressFunc) {
+	d.listeners = append(d.listeners, fn)
+}
+
+// retryPolicy returns the policy for retrying requests
+func (d *Downloader) retryPolicy() *utils.RetryPolicy {
+	if d.RetryPolicy == nil {
+		return utils.DefaultRetryPolicy()
+	}
+	return d.RetryPolicy
+}
+
+// SetHTTPClient sets the client sending every request, such as one with
+// instrumentation or a test double
+func (d *Downloader) SetHTTPClient(client *http.Client) {
+	d.Client = client
+}
+
+// SetTransport sets the transport of the client sending every request
+func (d *Downloader) SetTransport(transport http.RoundTripper) {
+	d.Client = utils.NewClient(transport)
+}
+
+// SetProxy sends every request through proxyURL, an http://, https:// or
+// socks5:// URL with optional credentials, except requests for hosts
+// matched by the NO_PROXY-style list noProxy. If proxyURL is empty, the
+// proxy from the environment is used, still excluding noProxy. It replaces
+// Client.
+func (d *Downloader) SetProxy(proxyURL, noProxy string) error {
+	d.clientOptions.Proxy = proxyURL
+	d.clientOptions.NoProxy = noProxy
+	return d.rebuildClient()
+}
+
+// SetTLS sets trusted CAs, client certificates, pinned keys and other TLS
+// options for every connection. It replaces Client.
+func (d *Downloader) SetTLS(opts *utils.TLSOptions) error {
+	d.clientOptions.TLS = opts
+	return d.rebuildClient()
+}
+
+// SetTimeouts sets the timeouts of connecting, the TLS handshake and
+// waiting for the response headers, which configure Client, and those
+// aborting responses that stall or arrive too slowly
+func (d *Downloader) SetTimeouts(timeouts utils.Timeouts) error {
+	d.Timeouts = timeouts
+	d.clientOptions.Timeouts = timeouts
+	return d.rebuildClient()
+}
+
+// rebuildClient replaces Client with one configured by the client options
+func (d *Downloader) rebuildClient() error {
+	client, err := utils.NewHTTPClient(d.clientOptions)
+	if err != nil {
+		return err
+	}
+	d.Client = client
+	return nil
+}
+
+// SetTrustRedirects sets whether credentials are also sent to hosts that
+// the URL or a mirror redirects to
+func (d *Downloader) SetTrustRedirects(trust bool) {
+	d.TrustRedirects = trust
+}
+
+// SetRequestOptions sets the headers, credentials and cookies added to
+// every request
+func (d *Downloader) SetRequestOptions(opts *utils.RequestOptions) {
+	d.Request = opts
+}
+
+// SetRetryPolicy sets how failed requests are retried before a chunk is
+// marked as failed
+func (d *Downloader) SetRetryPolicy(policy *utils.RetryPolicy) {
+	d.RetryPolicy = policy
+}
+
+// limiters returns the rate limiters applying to this download
+func (d *Downloader) limiters() []*utils.RateLimiter {
+	var limiters []*utils.RateLimiter
+	for _, limiter := range []*utils.RateLimiter{d.RateLimiter, d.SharedRateLimiter} {
+		if limiter != nil {
+			limiters = append(limiters, limiter)
+		}
+	}
+	return limiters
+}
+
+// SetRateLimit limits the download to bytesPerSecond, or removes the limit
+// if it is zero. It may be called while the download is running.
+func (d *Downloader) SetRateLimit(bytesPerSecond int64) {
+	d.RateLimiter.SetRate(bytesPerSecond)
+}
+
+// SetSharedRateLimiter adds a limiter shared with other downloads, capping
+// their combined bandwidth
+func (d *Downloader) SetSharedRateLimiter(limiter *utils.RateLimiter) {
+	d.SharedRateLimiter = limiter
+}
+
+// SetOutputDir sets the directory the file is saved in when no output path
+// was given and its name is resolved from the response
+func (d *Downloader) SetOutputDir(dir string) {
+	d.OutputDir = dir
+	if d.resolveName {
+		d.OutputPath = filepath.Join(dir, filepath.Base(d.OutputPath))
+	}
+}
+
+// SetPartPath sets the file written until the download completes, instead
+// of <output>.part. It must be on the same filesystem as the output.
+func (d *Downloader) SetPartPath(path string) {
+	d.PartPath = path
+}
+
+// SetPreserveModTime sets whether the finished file gets the server's
+// Last-Modified as its modification time
+func (d *Downloader) SetPreserveModTime(preserve bool) {
+	d.PreserveModTime = preserve
+}
+
+// SetOnExists sets what happens when the output file already exists
+func (d *Downloader) SetOnExists(policy ExistsPolicy) {
+	d.OnExists = policy
+}
+
+// SetPathLocks shares locks on output paths with other downloads, so those
+// resolving to the same path run one after the other
+func (d *Downloader) SetPathLocks(locks *PathLocks) {
+	d.PathLocks = locks
+}
+
+// SetMirrors sets additional URLs serving the same file. Chunks are spread
+// across the mirrors and moved to another one when a mirror fails.
+func (d *Downloader) SetMirrors(mirrors []string) {
+	d.Mirrors = mirrors
+}
+
+// SetPreallocate sets whether chunks are written directly into an output
+// file preallocated to the full size, instead of temp files that are merged
+func (d *Downloader) SetPreallocate(preallocate bool) {
+	d.Preallocate = preallocate
+}
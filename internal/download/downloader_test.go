@@ -1,14 +1,26 @@
 package download
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/godownloader/internal/utils"
 )
 
 func TestNewDownloader(t *testing.T) {
@@ -117,6 +129,92 @@ func setupTestServer(t *testing.T, supportsRanges bool, contentLength int64) *ht
 	return httptest.NewServer(handler)
 }
 
+// testContent returns n bytes of recognisable test data
+func testContent(n int) []byte {
+	content := make([]byte, n)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	return content
+}
+
+// fastRetryPolicy retries like the default policy without waiting long
+func fastRetryPolicy() *utils.RetryPolicy {
+	policy := utils.DefaultRetryPolicy()
+	policy.BaseDelay = time.Millisecond
+	return policy
+}
+
+// setupContentServer serves content with range support and records the Range
+// header of every GET request
+func setupContentServer(t *testing.T, content []byte) (*httptest.Server, func() []string) {
+	var mu sync.Mutex
+	var ranges []string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("ETag", `"v1"`)
+		if r.Method == "HEAD" {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+			return
+		}
+
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+
+		var start, end int64
+		if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end); err != nil {
+			w.Write(content)
+			return
+		}
+
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content[start : end+1])
+	}))
+
+	return server, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), ranges...)
+	}
+}
+
+func TestDownloadPreallocated(t *testing.T) {
+	content := testContent(10000)
+	server, _ := setupContentServer(t, content)
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	outputPath := filepath.Join(tempDir, "output.bin")
+	downloader := NewDownloader(server.URL, outputPath, 4)
+	downloader.SetVerbose(false)
+	downloader.SetPreallocate(true)
+
+	if err := downloader.Start(); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Preallocated output does not match the remote content")
+	}
+
+	// No chunk files are used
+	if _, err := os.Stat(PartsDir(outputPath)); !os.IsNotExist(err) {
+		t.Error("Expected no parts directory for a preallocated download")
+	}
+}
+
 func TestDownloadSingleThreaded(t *testing.T) {
 	// Skip in automated tests since it requires network operations
 	// Remove this skip when testing locally or in an environment that allows network access
@@ -229,3 +327,949 @@ func TestMergeChunks(t *testing.T) {
 		t.Errorf("Expected byte at position 101 to be 2, got %d", mergedFile[101])
 	}
 }
+
+func TestStartContextCanceled(t *testing.T) {
+	server := setupTestServer(t, true, 1024)
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	downloader := NewDownloader(server.URL, filepath.Join(tempDir, "output.zip"), 4)
+	downloader.SetVerbose(false)
+
+	err = downloader.StartContext(ctx)
+	if err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestDownloadChecksum(t *testing.T) {
+	content := testContent(10000)
+	server, _ := setupContentServer(t, content)
+	defer server.Close()
+
+	sum := sha256.Sum256(content)
+	good := &utils.Checksum{Algorithm: "sha256", Digest: hex.EncodeToString(sum[:])}
+	bad := &utils.Checksum{Algorithm: "sha256", Digest: strings.Repeat("0", 64)}
+
+	tests := []struct {
+		name        string
+		threads     int
+		preallocate bool
+	}{
+		{"SingleThreaded", 1, false},
+		{"MultiThreaded", 4, false},
+		{"Preallocated", 4, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir, err := os.MkdirTemp("", "downloader_test")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			// Matching checksum
+			outputPath := filepath.Join(tempDir, "good.bin")
+			downloader := NewDownloader(server.URL, outputPath, tt.threads)
+			downloader.SetVerbose(false)
+			downloader.SetPreallocate(tt.preallocate)
+			downloader.SetChecksum(good)
+
+			if err := downloader.Start(); err != nil {
+				t.Fatalf("Download failed: %v", err)
+			}
+			if downloader.ActualChecksum != good.Digest {
+				t.Errorf("Expected computed digest %s, got %s", good.Digest, downloader.ActualChecksum)
+			}
+
+			// Mismatching checksum
+			outputPath = filepath.Join(tempDir, "bad.bin")
+			downloader = NewDownloader(server.URL, outputPath, tt.threads)
+			downloader.SetVerbose(false)
+			downloader.SetPreallocate(tt.preallocate)
+			downloader.SetChecksum(bad)
+
+			err = downloader.Start()
+			var mismatch *utils.ChecksumMismatchError
+			if !errors.As(err, &mismatch) {
+				t.Fatalf("Expected ChecksumMismatchError, got %v", err)
+			}
+			if mismatch.Actual != good.Digest {
+				t.Errorf("Expected actual digest %s, got %s", good.Digest, mismatch.Actual)
+			}
+
+			// The data is quarantined, not left under the output name
+			if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+				t.Error("Expected no file at the output path")
+			}
+			data, err := os.ReadFile(QuarantinePath(outputPath))
+			if err != nil {
+				t.Fatalf("Expected quarantined file: %v", err)
+			}
+			if !bytes.Equal(data, content) {
+				t.Error("Quarantined file does not match the downloaded content")
+			}
+		})
+	}
+}
+
+func TestDownloadRateLimited(t *testing.T) {
+	content := testContent(60000)
+	server, _ := setupContentServer(t, content)
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	outputPath := filepath.Join(tempDir, "output.bin")
+	downloader := NewDownloader(server.URL, outputPath, 4)
+	downloader.SetVerbose(false)
+	downloader.SetRateLimit(40000)
+
+	// The shared limiter is looser, so the per-download limit applies
+	downloader.SetSharedRateLimiter(utils.NewRateLimiter(1 << 20))
+
+	// A one second burst, then 20000 bytes at 40000 bytes per second
+	start := time.Now()
+	if err := downloader.Start(); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("Expected the download to be throttled, took %v", elapsed)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Rate limited output does not match the remote content")
+	}
+}
+
+func TestProgressEvents(t *testing.T) {
+	content := testContent(10000)
+	server, _ := setupContentServer(t, content)
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	outputPath := filepath.Join(tempDir, "output.bin")
+	downloader := NewDownloader(server.URL, outputPath, 4)
+	downloader.SetVerbose(false)
+	downloader.SetChecksum(&utils.Checksum{Algorithm: "sha256"})
+
+	var events []ProgressEvent
+	downloader.Subscribe(func(event ProgressEvent) {
+		events = append(events, event)
+	})
+
+	if err := downloader.Start(); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	// Lifecycle events arrive in order, with progress updates in between
+	var lifecycle []EventType
+	completed := make(map[int]bool)
+	for _, event := range events {
+		switch event.Type {
+		case EventProgress:
+		case EventChunkComplete:
+			completed[event.ChunkID] = true
+		default:
+			lifecycle = append(lifecycle, event.Type)
+		}
+	}
+	expected := []EventType{EventProbing, EventDownloading, EventMerging, EventVerifying, EventDone}
+	if !slices.Equal(lifecycle, expected) {
+		t.Errorf("Expected events %v, got %v", expected, lifecycle)
+	}
+
+	if len(completed) != 4 {
+		t.Errorf("Expected 4 chunk completions, got %d", len(completed))
+	}
+
+	done := events[len(events)-1]
+	sum := sha256.Sum256(content)
+	if done.Checksum != "sha256:"+hex.EncodeToString(sum[:]) {
+		t.Errorf("Unexpected checksum in done event: %s", done.Checksum)
+	}
+	if done.Downloaded != int64(len(content)) || done.TotalSize != int64(len(content)) {
+		t.Errorf("Expected %d of %d bytes when done, got %d of %d", len(content), len(content), done.Downloaded, done.TotalSize)
+	}
+	if done.OutputPath != outputPath || done.URL != server.URL {
+		t.Errorf("Unexpected event target: %s -> %s", done.URL, done.OutputPath)
+	}
+	if len(done.Chunks) != 4 {
+		t.Errorf("Expected 4 chunk states, got %d", len(done.Chunks))
+	}
+	for _, chunk := range done.Chunks {
+		if !chunk.Completed {
+			t.Errorf("Expected chunk %d to be completed", chunk.ID)
+		}
+	}
+
+	// A failed download ends with EventFailed carrying the error
+	events = nil
+	failing := NewDownloader(server.URL+"/missing", filepath.Join(tempDir, "missing.bin"), 4)
+	failing.SetVerbose(false)
+	failing.SetMaxRetries(0)
+	server.Close()
+	failing.Subscribe(func(event ProgressEvent) {
+		events = append(events, event)
+	})
+
+	err = failing.Start()
+	if err == nil {
+		t.Fatal("Expected download from a closed server to fail")
+	}
+	if last := events[len(events)-1]; last.Type != EventFailed || last.Err != err {
+		t.Errorf("Expected a failed event with the download error, got %+v", last)
+	}
+}
+
+func TestDownloadResourceChanged(t *testing.T) {
+	content := testContent(10000)
+
+	// The file is republished after the first chunk request
+	var mu sync.Mutex
+	etag := `"v1"`
+	var ifRanges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("ETag", etag)
+		if r.Method == "HEAD" {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+			return
+		}
+
+		ifRanges = append(ifRanges, r.Header.Get("If-Range"))
+		current := etag
+		etag = `"v2"`
+
+		var start, end int64
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		if r.Header.Get("If-Range") != current {
+			w.Write(content)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content[start : end+1])
+	}))
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	outputPath := filepath.Join(tempDir, "output.bin")
+	downloader := NewDownloader(server.URL, outputPath, 4)
+	downloader.SetVerbose(false)
+
+	err = downloader.Start()
+	if !errors.Is(err, ErrResourceChanged) {
+		t.Fatalf("Expected ErrResourceChanged, got %v", err)
+	}
+
+	mu.Lock()
+	for _, ifRange := range ifRanges {
+		if ifRange != `"v1"` {
+			t.Errorf("Expected If-Range %q on every chunk request, got %q", `"v1"`, ifRange)
+		}
+	}
+	mu.Unlock()
+
+	// Nothing is kept to resume from, and no output is produced
+	for _, path := range []string{outputPath, StatePath(outputPath), PartsDir(outputPath)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", path)
+		}
+	}
+}
+
+func TestDownloadUnknownLength(t *testing.T) {
+	content := testContent(100000)
+
+	// A chunked response without Content-Length or range support
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		if r.Method == "GET" {
+			w.Write(content)
+		}
+	}))
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	sum := sha256.Sum256(content)
+	outputPath := filepath.Join(tempDir, "output.bin")
+	downloader := NewDownloader(server.URL, outputPath, 4)
+	downloader.SetVerbose(false)
+	downloader.SetChecksum(&utils.Checksum{Algorithm: "sha256", Digest: hex.EncodeToString(sum[:])})
+
+	var last ProgressEvent
+	downloader.Subscribe(func(event ProgressEvent) {
+		if event.Type == EventProgress {
+			last = event
+		}
+	})
+
+	if err := downloader.Start(); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Output does not match the streamed content")
+	}
+
+	// Progress reports bytes without a size or ETA
+	if last.TotalSize != -1 || last.Downloaded != int64(len(content)) || last.ETA != 0 {
+		t.Errorf("Unexpected progress for unknown size: %+v", last)
+	}
+}
+
+func TestDownloadEmptyFile(t *testing.T) {
+	server, _ := setupContentServer(t, nil)
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A known size of 0 with range support has nothing to split
+	sum := sha256.Sum256(nil)
+	outputPath := filepath.Join(tempDir, "output.bin")
+	downloader := NewDownloader(server.URL, outputPath, 4)
+	downloader.SetVerbose(false)
+	downloader.SetChecksum(&utils.Checksum{Algorithm: "sha256", Digest: hex.EncodeToString(sum[:])})
+
+	if err := downloader.Start(); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to stat output: %v", err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("Expected an empty output, got %d bytes", info.Size())
+	}
+	if exists(PartPath(outputPath)) || exists(StatePath(outputPath)) {
+		t.Error("Expected no part or state file to be left behind")
+	}
+}
+
+func TestDownloadSingleThreadedResume(t *testing.T) {
+	content := testContent(10000)
+
+	var mu sync.Mutex
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// An earlier run stopped after 4000 bytes
+	outputPath := filepath.Join(tempDir, "output.bin")
+	if err := os.WriteFile(PartPath(outputPath), content[:4000], 0644); err != nil {
+		t.Fatalf("Failed to write partial output: %v", err)
+	}
+	state := NewState(server.URL, int64(len(content)), `"v1"`, "", nil)
+	if err := state.Save(StatePath(outputPath)); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	sum := sha256.Sum256(content)
+	downloader := NewDownloader(server.URL, outputPath, 1)
+	downloader.SetVerbose(false)
+	downloader.SetChecksum(&utils.Checksum{Algorithm: "sha256", Digest: hex.EncodeToString(sum[:])})
+
+	if err := downloader.Start(); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	mu.Lock()
+	if len(ranges) != 1 || ranges[0] != "bytes=4000-" {
+		t.Errorf("Expected a single request for bytes=4000-, got %q", ranges)
+	}
+	mu.Unlock()
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Resumed output does not match the remote content")
+	}
+
+	if _, err := os.Stat(StatePath(outputPath)); !os.IsNotExist(err) {
+		t.Error("Expected the state file to be removed after completion")
+	}
+}
+
+func TestDownloadResolvesFilename(t *testing.T) {
+	content := testContent(1000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="../report 2024.pdf"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The URL alone would name the file "download"
+	downloader := NewDownloader(server.URL+"/download?id=42&token=secret", "", 2)
+	downloader.SetVerbose(false)
+	downloader.SetOutputDir(tempDir)
+
+	if expected := filepath.Join(tempDir, "download"); downloader.OutputPath != expected {
+		t.Errorf("Expected OutputPath %s before probing, got %s", expected, downloader.OutputPath)
+	}
+
+	if err := downloader.Start(); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	expected := filepath.Join(tempDir, "report 2024.pdf")
+	if downloader.OutputPath != expected {
+		t.Errorf("Expected OutputPath %s, got %s", expected, downloader.OutputPath)
+	}
+	if data, err := os.ReadFile(expected); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("Expected the content in %s: %v", expected, err)
+	}
+}
+
+func TestDownloadRequestOptions(t *testing.T) {
+	content := testContent(10000)
+
+	// Every request, the probe included, must carry the credentials
+	var mu sync.Mutex
+	var rejected []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		cookie, err := r.Cookie("session")
+		if !ok || user != "alice" || password != "secret" || err != nil || cookie.Value != "abc" ||
+			r.Header.Get("X-Api-Key") != "k" || r.UserAgent() != "test-agent" {
+			mu.Lock()
+			rejected = append(rejected, r.Method+" "+r.Header.Get("Range"))
+			mu.Unlock()
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	jar, err := utils.ParseCookies(strings.NewReader(strings.Split(host, ":")[0] + "\tFALSE\t/\tFALSE\t0\tsession\tabc\n"))
+	if err != nil {
+		t.Fatalf("ParseCookies failed: %v", err)
+	}
+
+	for _, threads := range []int{1, 4} {
+		t.Run(fmt.Sprintf("%d threads", threads), func(t *testing.T) {
+			tempDir, err := os.MkdirTemp("", "downloader_test")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			outputPath := filepath.Join(tempDir, "output.bin")
+			downloader := NewDownloader(server.URL, outputPath, threads)
+			downloader.SetVerbose(false)
+			downloader.SetRequestOptions(&utils.RequestOptions{
+				Headers:   http.Header{"X-Api-Key": {"k"}},
+				UserAgent: "test-agent",
+				Username:  "alice",
+				Password:  "secret",
+				Cookies:   jar,
+			})
+
+			if err := downloader.Start(); err != nil {
+				t.Fatalf("Download failed: %v", err)
+			}
+
+			data, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatalf("Failed to read output: %v", err)
+			}
+			if !bytes.Equal(data, content) {
+				t.Error("Downloaded content does not match")
+			}
+		})
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(rejected) > 0 {
+		t.Errorf("Requests without credentials: %q", rejected)
+	}
+}
+
+func TestDownloadFollowsRedirect(t *testing.T) {
+	content := testContent(10000)
+
+	var mu sync.Mutex
+	var storeRequests int
+	var leaked []string
+	blob := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" || r.Header.Get("X-Api-Key") != "" {
+			mu.Lock()
+			leaked = append(leaked, r.Method+" "+r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer blob.Close()
+
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		storeRequests++
+		mu.Unlock()
+		http.Redirect(w, r, blob.URL+"/blob?sig=abc", http.StatusFound)
+	}))
+	defer store.Close()
+
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	downloader := NewDownloader(store.URL+"/file.bin", filepath.Join(tempDir, "output.bin"), 4)
+	downloader.SetVerbose(false)
+	downloader.SetRequestOptions(&utils.RequestOptions{
+		BearerToken: "token",
+		Headers:     http.Header{"X-Api-Key": {"secret"}},
+	})
+
+	if err := downloader.Start(); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	if downloader.ResolvedURL != blob.URL+"/blob?sig=abc" {
+		t.Errorf("ResolvedURL = %q, want the blob URL", downloader.ResolvedURL)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// Only the probe goes through the redirect; chunks go to the blob store
+	if storeRequests != 1 {
+		t.Errorf("Expected 1 request to the redirecting server, got %d", storeRequests)
+	}
+	if len(leaked) > 0 {
+		t.Errorf("Credentials sent to the redirect target: %q", leaked)
+	}
+}
+
+func TestDownloadChunkRedirect(t *testing.T) {
+	content := testContent(10000)
+
+	var mu sync.Mutex
+	var blobRequests int
+	var leaked []string
+	blob := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		blobRequests++
+		if r.Header.Get("Authorization") != "" || r.Header.Get("X-Api-Key") != "" {
+			leaked = append(leaked, r.Method+" "+r.Header.Get("Range"))
+		}
+		mu.Unlock()
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer blob.Close()
+
+	// The store answers HEAD itself but redirects every GET, like a store
+	// handing out a presigned URL per request
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			http.Redirect(w, r, blob.URL+"/blob?sig=abc", http.StatusFound)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer store.Close()
+
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	outputPath := filepath.Join(tempDir, "output.bin")
+	downloader := NewDownloader(store.URL+"/file.bin", outputPath, 4)
+	downloader.SetVerbose(false)
+	downloader.SetRequestOptions(&utils.RequestOptions{
+		BearerToken: "token",
+		Headers:     http.Header{"X-Api-Key": {"secret"}},
+	})
+
+	if err := downloader.Start(); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Output does not match the remote content")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if blobRequests == 0 {
+		t.Error("Expected the chunks to be redirected to the blob store")
+	}
+	if len(leaked) > 0 {
+		t.Errorf("Credentials sent to the redirect target: %q", leaked)
+	}
+}
+
+func TestDownloadThroughProxy(t *testing.T) {
+	content := testContent(10000)
+
+	// The proxy serves the file for any URL instead of forwarding
+	var mu sync.Mutex
+	var hosts []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hosts = append(hosts, r.URL.Host)
+		mu.Unlock()
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer proxy.Close()
+
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	outputPath := filepath.Join(tempDir, "output.bin")
+	downloader := NewDownloader("http://files.example.invalid/output.bin", outputPath, 4)
+	downloader.SetVerbose(false)
+	if err := downloader.SetProxy(proxy.URL, "localhost"); err != nil {
+		t.Fatalf("SetProxy failed: %v", err)
+	}
+
+	if err := downloader.Start(); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Downloaded content does not match")
+	}
+
+	// The probe and every chunk went through the proxy
+	mu.Lock()
+	defer mu.Unlock()
+	if len(hosts) < 5 {
+		t.Errorf("Expected the probe and 4 chunks to be proxied, got %d requests", len(hosts))
+	}
+	for _, host := range hosts {
+		if host != "files.example.invalid" {
+			t.Errorf("Unexpected proxied host %q", host)
+		}
+	}
+}
+
+func TestDownloadPrivateCA(t *testing.T) {
+	content := testContent(10000)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	caFile := filepath.Join(tempDir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0644); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	outputPath := filepath.Join(tempDir, "output.bin")
+	downloader := NewDownloader(server.URL, outputPath, 4)
+	downloader.SetVerbose(false)
+	if err := downloader.SetTLS(&utils.TLSOptions{CAFiles: []string{caFile}}); err != nil {
+		t.Fatalf("SetTLS failed: %v", err)
+	}
+
+	if err := downloader.Start(); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Downloaded content does not match")
+	}
+}
+
+// countingTransport counts the requests it passes on to the shared transport
+type countingTransport struct {
+	mu       sync.Mutex
+	requests int
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.requests++
+	c.mu.Unlock()
+	return utils.DefaultTransport().RoundTrip(req)
+}
+
+func TestDownloadTransport(t *testing.T) {
+	content := testContent(10000)
+
+	var mu sync.Mutex
+	var conns int
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			mu.Lock()
+			conns++
+			mu.Unlock()
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	transport := &countingTransport{}
+	for i := range 2 {
+		outputPath := filepath.Join(tempDir, fmt.Sprintf("output%d.bin", i))
+		downloader := NewDownloader(server.URL, outputPath, 4)
+		downloader.SetVerbose(false)
+		downloader.SetTransport(transport)
+
+		if err := downloader.Start(); err != nil {
+			t.Fatalf("Download failed: %v", err)
+		}
+
+		data, err := os.ReadFile(outputPath)
+		if err != nil {
+			t.Fatalf("Failed to read output: %v", err)
+		}
+		if !bytes.Equal(data, content) {
+			t.Error("Downloaded content does not match")
+		}
+	}
+
+	// The probe and every chunk of both downloads went through the transport
+	if transport.requests < 10 {
+		t.Errorf("Expected the probes and 8 chunks to use the transport, got %d requests", transport.requests)
+	}
+
+	// The second download reused the connections of the first
+	mu.Lock()
+	defer mu.Unlock()
+	if conns > 5 {
+		t.Errorf("Expected at most 5 connections, got %d", conns)
+	}
+}
+
+func TestDownloadStalledChunk(t *testing.T) {
+	content := testContent(10000)
+
+	// The first response sends part of its range and then hangs
+	var mu sync.Mutex
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Method != "GET" {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+			return
+		}
+
+		mu.Lock()
+		requests++
+		first := requests == 1
+		mu.Unlock()
+
+		if first {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-4999/%d", len(content)))
+			w.Header().Set("Content-Length", "5000")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(content[:1000])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	outputPath := filepath.Join(tempDir, "output.bin")
+	downloader := NewDownloader(server.URL, outputPath, 2)
+	downloader.SetVerbose(false)
+	downloader.SetMinSplitSize(0)
+	if err := downloader.SetTimeouts(utils.Timeouts{Stall: 100 * time.Millisecond}); err != nil {
+		t.Fatalf("SetTimeouts failed: %v", err)
+	}
+
+	var retried []error
+	downloader.Subscribe(func(event ProgressEvent) {
+		if event.Type == EventRetrying {
+			retried = append(retried, event.Err)
+		}
+	})
+
+	if err := downloader.Start(); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Downloaded content does not match")
+	}
+
+	if len(retried) != 1 || !errors.Is(retried[0], utils.ErrStalled) {
+		t.Errorf("Expected one chunk retried after stalling, got %v", retried)
+	}
+}
+
+func TestDownloadChecksumAfterDiscardedChunk(t *testing.T) {
+	content := testContent(10000)
+	sum := sha256.Sum256(content)
+
+	// The first response for the start of the file sends wrong bytes and
+	// breaks off once they may have been hashed
+	var mu sync.Mutex
+	var corrupted bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		mu.Lock()
+		corrupt := !corrupted && r.Method == "GET" && strings.HasPrefix(r.Header.Get("Range"), "bytes=0-")
+		corrupted = corrupted || corrupt
+		mu.Unlock()
+
+		if corrupt {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-4999/%d", len(content)))
+			w.Header().Set("Content-Length", "5000")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(make([]byte, 3000))
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	outputPath := filepath.Join(tempDir, "output.bin")
+	downloader := NewDownloader(server.URL, outputPath, 2)
+	downloader.SetVerbose(false)
+	downloader.SetMinSplitSize(0)
+	downloader.SetChecksum(&utils.Checksum{Algorithm: "sha256", Digest: hex.EncodeToString(sum[:])})
+	downloader.SetRetryPolicy(fastRetryPolicy())
+
+	var retried []error
+	downloader.Subscribe(func(event ProgressEvent) {
+		if event.Type == EventRetrying {
+			retried = append(retried, event.Err)
+		}
+	})
+
+	if err := downloader.Start(); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	if !slices.ContainsFunc(retried, func(err error) bool { return errors.Is(err, ErrTailMismatch) }) {
+		t.Errorf("Expected the corrupt chunk to be discarded, got retries %v", retried)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Downloaded content does not match")
+	}
+}
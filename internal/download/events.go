@@ -0,0 +1,124 @@
+package download
+
+import (
+	"fmt"
+	"time"
+)
+
+// EventType identifies what a ProgressEvent reports
+type EventType string
+
+// Event types, in the order a download goes through them
+const (
+	EventProbing       EventType = "probing"
+	EventDownloading   EventType = "downloading"
+	EventProgress      EventType = "progress"
+	EventChunkComplete EventType = "chunk_complete"
+	EventRetrying      EventType = "retrying"
+	EventVerifying     EventType = "verifying"
+	EventMerging       EventType = "merging"
+	EventDone          EventType = "done"
+	EventSkipped       EventType = "skipped"
+	EventFailed        EventType = "failed"
+)
+
+// ChunkState is a snapshot of one chunk's progress
+type ChunkState struct {
+	ID         int
+	Start      int64
+	End        int64
+	Downloaded int64
+	Completed  bool
+	Failed     bool
+	RetryCount int
+}
+
+// ProgressEvent reports a lifecycle change or a periodic progress update
+type ProgressEvent struct {
+	Type       EventType
+	URL        string
+	OutputPath string
+
+	// TotalSize is 0 until the file has been probed, and -1 if the server
+	// doesn't report it
+	TotalSize  int64
+	Downloaded int64
+
+	// Speed is in bytes per second; ETA is 0 while unknown
+	Speed   float64
+	ETA     time.Duration
+	Elapsed time.Duration
+
+	// Chunks is empty for single-threaded downloads
+	Chunks []ChunkState
+
+	// ChunkID is the chunk that completed or is being retried
+	ChunkID int
+
+	// Checksum is the computed digest as "algorithm:hexdigest" for EventDone
+	// and EventSkipped, if a checksum was requested
+	Checksum string
+
+	// Err is the cause of EventRetrying and EventFailed
+	Err error
+}
+
+// ProgressFunc receives progress events. It is called synchronously from
+// the download, so it should return quickly.
+type ProgressFunc func(ProgressEvent)
+
+// ConsoleRenderer prints events to stdout as a progress bar followed by a
+// summary
+type ConsoleRenderer struct {
+	// drawing is set while the cursor is at the end of the progress bar
+	drawing bool
+}
+
+// Render prints one event
+func (r *ConsoleRenderer) Render(event ProgressEvent) {
+	if event.Type == EventProgress && event.TotalSize < 0 {
+		// Without a size there is nothing to show progress against
+		fmt.Printf("\r%.2f MB (%.2f MB/s)",
+			float64(event.Downloaded)/(1024*1024),
+			event.Speed/(1024*1024),
+		)
+		r.drawing = true
+		return
+	}
+
+	if event.Type == EventProgress {
+		fmt.Printf("\r%s %.2f%% %.2f MB/%.2f MB (%.2f MB/s) ETA: %s",
+			progressBar(event.Downloaded, event.TotalSize),
+			percent(event.Downloaded, event.TotalSize),
+			float64(event.Downloaded)/(1024*1024),
+			float64(event.TotalSize)/(1024*1024),
+			event.Speed/(1024*1024),
+			event.ETA.Round(time.Second),
+		)
+		r.drawing = true
+		return
+	}
+
+	// Add a newline after the progress bar
+	if r.drawing {
+		fmt.Println()
+		r.drawing = false
+	}
+
+	switch event.Type {
+	case EventRetrying:
+		fmt.Printf("Retrying chunk %d: %v\n", event.ChunkID, event.Err)
+	case EventVerifying:
+		fmt.Println("Verifying checksum...")
+	case EventMerging:
+		fmt.Println("Merging chunks...")
+	case EventSkipped:
+		fmt.Printf("Already downloaded: %s\n", event.OutputPath)
+	case EventDone:
+		fmt.Printf("\nDownload Summary:\n")
+		fmt.Printf("Total size: %.2f MB\n", float64(max(event.TotalSize, event.Downloaded))/(1024*1024))
+		fmt.Printf("Time taken: %s\n", event.Elapsed.Round(time.Second))
+		fmt.Printf("Average speed: %.2f MB/s\n", event.Speed/(1024*1024))
+		fmt.Printf("Download completed: %s\n", event.OutputPath)
+	}
+}
@@ -0,0 +1,214 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ExistsPolicy decides what happens when the output file already exists
+type ExistsPolicy string
+
+// Policies for an existing output file. Partial data of an interrupted run
+// lives in the part file, so the file at the output path is always a
+// finished one; if the policy lets the download go ahead, the part file is
+// resumed as usual.
+const (
+	// ExistsOverwrite replaces the file
+	ExistsOverwrite ExistsPolicy = "overwrite"
+	// ExistsSkip keeps the file if its size matches, its recorded ETag (if
+	// any) matches, it is not older than the remote Last-Modified and, if a
+	// checksum is set, matches it. Otherwise the file is replaced.
+	ExistsSkip ExistsPolicy = "skip"
+	// ExistsFail fails with ErrFileExists
+	ExistsFail ExistsPolicy = "fail"
+	// ExistsRename saves to a free name such as "file (1).zip"
+	ExistsRename ExistsPolicy = "rename"
+	// ExistsResume treats a smaller file as the start of the download and
+	// fetches only the rest, and skips a file that is already complete. A
+	// file whose recorded ETag differs is downloaded again instead.
+	ExistsResume ExistsPolicy = "resume"
+)
+
+// ErrFileExists is returned by ExistsFail when the output file exists
+var ErrFileExists = errors.New("output file already exists")
+
+// ParseExistsPolicy parses a policy name
+func ParseExistsPolicy(s string) (ExistsPolicy, error) {
+	policy := ExistsPolicy(strings.ToLower(s))
+	switch policy {
+	case ExistsOverwrite, ExistsSkip, ExistsFail, ExistsRename, ExistsResume:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid policy %q, expected overwrite, skip, fail, rename or resume", s)
+	}
+}
+
+// checkExisting applies the OnExists policy to a file already at the output
+// path. It may set Skipped, pick another output path or arrange for the
+// file to be resumed.
+func (d *Downloader) checkExisting() error {
+	info, err := os.Stat(d.OutputPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check output file: %w", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("output path %s is a directory", d.OutputPath)
+	}
+
+	switch d.OnExists {
+	case ExistsSkip:
+		d.Skipped, err = d.isCurrent(info)
+		return err
+	case ExistsFail:
+		return fmt.Errorf("%s: %w", d.OutputPath, ErrFileExists)
+	case ExistsRename:
+		d.OutputPath = freePath(d.OutputPath, d.tryLockPath)
+	case ExistsResume:
+		switch {
+		case d.ContentLength >= 0 && info.Size() == d.ContentLength:
+			d.Skipped, err = d.isCurrent(info)
+			return err
+		case d.ContentLength >= 0 && info.Size() > d.ContentLength, d.etagChanged():
+			// Not a prefix of the remote file, so start over
+		case exists(StatePath(d.OutputPath)):
+			// The part file of an interrupted run is further along
+		case d.SupportsRanges:
+			// Continue the file as the part file of this download
+			if err := os.Rename(d.OutputPath, d.partPath()); err != nil {
+				return fmt.Errorf("failed to resume output file: %w", err)
+			}
+			d.resumeExisting = true
+		}
+	}
+
+	return nil
+}
+
+// isCurrent reports whether an existing output file is the remote file
+func (d *Downloader) isCurrent(info os.FileInfo) (bool, error) {
+	if d.ContentLength < 0 || info.Size() != d.ContentLength {
+		return false, nil
+	}
+
+	if d.etagChanged() {
+		return false, nil
+	}
+
+	if modified, err := http.ParseTime(d.LastModified); err == nil && info.ModTime().Before(modified) {
+		return false, nil
+	}
+
+	if d.Checksum == nil {
+		return true, nil
+	}
+
+	h, err := d.Checksum.NewHash()
+	if err != nil {
+		return false, err
+	}
+
+	file, err := os.Open(d.OutputPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to open output file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return false, fmt.Errorf("failed to hash output file: %w", err)
+	}
+
+	d.ActualChecksum = fmt.Sprintf("%x", h.Sum(nil))
+	return d.Checksum.Verify(d.ActualChecksum) == nil, nil
+}
+
+// etagChanged reports whether the ETag recorded for the file at the output
+// path differs from the remote one, so the file is another version
+func (d *Downloader) etagChanged() bool {
+	etag, err := os.ReadFile(ETagPath(d.OutputPath))
+	return err == nil && d.ETag != "" && string(etag) != d.ETag
+}
+
+// recordETag keeps the ETag of the finished file next to it for ExistsSkip
+// and ExistsResume to compare later. Downloads with other policies, or of a
+// file without an ETag, remove any outdated record instead.
+func (d *Downloader) recordETag() error {
+	path := ETagPath(d.OutputPath)
+
+	if d.ETag == "" || (d.OnExists != ExistsSkip && d.OnExists != ExistsResume) {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove ETag record: %w", err)
+		}
+		return nil
+	}
+
+	if err := os.WriteFile(path, []byte(d.ETag), 0644); err != nil {
+		return fmt.Errorf("failed to record ETag: %w", err)
+	}
+	return nil
+}
+
+// freePath returns the first of "name (1).ext", "name (2).ext", ... that is
+// not taken by a file or a partial download and that claim accepts
+func freePath(path string, claim func(string) bool) string {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(path, ext)
+
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s (%d)%s", stem, i, ext)
+		if !exists(candidate) && !exists(StatePath(candidate)) && !exists(PartPath(candidate)) && claim(candidate) {
+			return candidate
+		}
+	}
+}
+
+// lockPath waits until no other download sharing PathLocks writes to path
+// and holds it until the download ends
+func (d *Downloader) lockPath(ctx context.Context, path string) error {
+	if d.PathLocks == nil {
+		return nil
+	}
+
+	unlock, err := d.PathLocks.Lock(ctx, path)
+	if err != nil {
+		return err
+	}
+	d.pathUnlocks = append(d.pathUnlocks, unlock)
+	return nil
+}
+
+// tryLockPath holds path until the download ends if no other download
+// sharing PathLocks writes to it, and reports whether it did
+func (d *Downloader) tryLockPath(path string) bool {
+	if d.PathLocks == nil {
+		return true
+	}
+
+	unlock, ok := d.PathLocks.TryLock(path)
+	if ok {
+		d.pathUnlocks = append(d.pathUnlocks, unlock)
+	}
+	return ok
+}
+
+// unlockPaths releases the paths held by the download
+func (d *Downloader) unlockPaths() {
+	for _, unlock := range d.pathUnlocks {
+		unlock()
+	}
+	d.pathUnlocks = nil
+}
+
+// exists reports whether anything is at path
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
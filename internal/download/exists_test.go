@@ -0,0 +1,187 @@
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godownloader/internal/utils"
+)
+
+func TestParseExistsPolicy(t *testing.T) {
+	for _, name := range []string{"overwrite", "skip", "fail", "rename", "Resume"} {
+		if _, err := ParseExistsPolicy(name); err != nil {
+			t.Errorf("ParseExistsPolicy(%q) failed: %v", name, err)
+		}
+	}
+
+	if _, err := ParseExistsPolicy("keep"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+func TestFreePath(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	path := filepath.Join(tempDir, "file.zip")
+
+	for _, name := range []string{"file.zip", "file (1).zip"} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), nil, 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	// A partial download also takes its name
+	if err := os.WriteFile(StatePath(filepath.Join(tempDir, "file (2).zip")), nil, 0644); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+
+	if got, want := freePath(path, func(string) bool { return true }), filepath.Join(tempDir, "file (3).zip"); got != want {
+		t.Errorf("freePath() = %q, want %q", got, want)
+	}
+}
+
+func TestDownloadOnExists(t *testing.T) {
+	content := testContent(10000)
+	sum := sha256.Sum256(content)
+	checksum := &utils.Checksum{Algorithm: "sha256", Digest: hex.EncodeToString(sum[:])}
+
+	// A same-sized file with different bytes
+	edited := bytes.Repeat([]byte("x"), len(content))
+
+	tests := []struct {
+		name      string
+		policy    ExistsPolicy
+		existing  []byte
+		checksum  *utils.Checksum
+		etag      string
+		withState bool
+		wantErr   error
+		wantSkip  bool
+		wantRange []string
+		wantData  []byte
+		wantPath  string
+	}{
+		{name: "overwrite", policy: ExistsOverwrite, existing: []byte("stale"), wantRange: []string{""}, wantData: content},
+		{name: "skip current", policy: ExistsSkip, existing: content, checksum: checksum, wantSkip: true, wantData: content},
+		{name: "skip same size", policy: ExistsSkip, existing: edited, wantSkip: true, wantData: edited},
+		{name: "skip checksum mismatch", policy: ExistsSkip, existing: edited, checksum: checksum, wantRange: []string{""}, wantData: content},
+		{name: "skip smaller", policy: ExistsSkip, existing: content[:10], wantRange: []string{""}, wantData: content},
+		{name: "skip same etag", policy: ExistsSkip, existing: edited, etag: `"v1"`, wantSkip: true, wantData: edited},
+		{name: "skip etag changed", policy: ExistsSkip, existing: content, etag: `"v0"`, wantRange: []string{""}, wantData: content},
+		{name: "fail", policy: ExistsFail, existing: edited, wantErr: ErrFileExists, wantData: edited},
+		{name: "fail with state", policy: ExistsFail, existing: edited, withState: true, wantErr: ErrFileExists, wantData: edited},
+		{name: "skip with state", policy: ExistsSkip, existing: edited, withState: true, wantSkip: true, wantData: edited},
+		{name: "rename", policy: ExistsRename, existing: edited, wantRange: []string{""}, wantData: edited, wantPath: "output (1).bin"},
+		{name: "resume partial", policy: ExistsResume, existing: content[:4000], checksum: checksum, wantRange: []string{"bytes=4000-"}, wantData: content},
+		{name: "resume complete", policy: ExistsResume, existing: content, wantSkip: true, wantData: content},
+		{name: "resume etag changed", policy: ExistsResume, existing: content[:4000], etag: `"v0"`, wantRange: []string{""}, wantData: content},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var ranges []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == "GET" {
+					mu.Lock()
+					ranges = append(ranges, r.Header.Get("Range"))
+					mu.Unlock()
+				}
+				w.Header().Set("ETag", `"v1"`)
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+			}))
+			defer server.Close()
+
+			tempDir, err := os.MkdirTemp("", "downloader_test")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			outputPath := filepath.Join(tempDir, "output.bin")
+			if err := os.WriteFile(outputPath, tt.existing, 0644); err != nil {
+				t.Fatalf("Failed to write existing file: %v", err)
+			}
+
+			if tt.etag != "" {
+				if err := os.WriteFile(ETagPath(outputPath), []byte(tt.etag), 0644); err != nil {
+					t.Fatalf("Failed to record ETag: %v", err)
+				}
+			}
+
+			// A stale state file of another download doesn't protect the file
+			if tt.withState {
+				state := &State{
+					URL:           "https://example.com/old.bin",
+					ContentLength: int64(len(content)),
+					Chunks:        []ChunkRecord{{ID: 0, Start: 0, End: int64(len(content)) - 1}},
+				}
+				if err := state.Save(StatePath(outputPath)); err != nil {
+					t.Fatalf("Failed to save state: %v", err)
+				}
+			}
+
+			downloader := NewDownloader(server.URL, outputPath, 1)
+			downloader.SetVerbose(false)
+			downloader.SetOnExists(tt.policy)
+			downloader.SetChecksum(tt.checksum)
+
+			err = downloader.Start()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Start() error = %v, want %v", err, tt.wantErr)
+			}
+			if downloader.Skipped != tt.wantSkip {
+				t.Errorf("Skipped = %v, want %v", downloader.Skipped, tt.wantSkip)
+			}
+
+			mu.Lock()
+			if len(ranges) != len(tt.wantRange) || (len(ranges) > 0 && ranges[0] != tt.wantRange[0]) {
+				t.Errorf("GET ranges = %q, want %q", ranges, tt.wantRange)
+			}
+			mu.Unlock()
+
+			data, err := os.ReadFile(outputPath)
+			if err != nil {
+				t.Fatalf("Failed to read output: %v", err)
+			}
+			if !bytes.Equal(data, tt.wantData) {
+				t.Error("Output file does not hold the expected content")
+			}
+
+			// A download with skip or resume records the ETag for next time
+			if len(tt.wantRange) > 0 && tt.wantPath == "" {
+				etag, err := os.ReadFile(ETagPath(outputPath))
+				recorded := tt.policy == ExistsSkip || tt.policy == ExistsResume
+				if recorded && string(etag) != `"v1"` {
+					t.Errorf("Expected the ETag to be recorded, got %q (%v)", etag, err)
+				}
+				if !recorded && err == nil {
+					t.Errorf("Expected no ETag record for %s, got %q", tt.policy, etag)
+				}
+			}
+
+			if tt.wantPath != "" {
+				renamed := filepath.Join(filepath.Dir(outputPath), tt.wantPath)
+				if downloader.OutputPath != renamed {
+					t.Errorf("OutputPath = %q, want %q", downloader.OutputPath, renamed)
+				}
+				data, err := os.ReadFile(renamed)
+				if err != nil || !bytes.Equal(data, content) {
+					t.Errorf("Expected the download in %s", renamed)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package download
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// partPath returns where the file is written until the download is complete
+func (d *Downloader) partPath() string {
+	if d.PartPath != "" {
+		return d.PartPath
+	}
+	return PartPath(d.OutputPath)
+}
+
+// finalize gives the complete, verified part file its output name and
+// records its ETag. The rename is atomic, so the output path never holds a
+// partial file.
+func (d *Downloader) finalize() error {
+	part := d.partPath()
+
+	if d.PreserveModTime {
+		if modified, err := http.ParseTime(d.LastModified); err == nil {
+			if err := os.Chtimes(part, time.Now(), modified); err != nil {
+				return fmt.Errorf("failed to set modification time: %w", err)
+			}
+		}
+	}
+
+	if err := os.Rename(part, d.OutputPath); err != nil {
+		return fmt.Errorf("failed to rename %s to %s: %w", part, d.OutputPath, err)
+	}
+
+	return d.recordETag()
+}
+
+// quarantine moves a part file that failed verification aside and returns
+// the verification error, recording where the file went
+func (d *Downloader) quarantine(verifyErr error) error {
+	quarantinePath := QuarantinePath(d.OutputPath)
+	if err := os.Rename(d.partPath(), quarantinePath); err != nil {
+		return fmt.Errorf("failed to quarantine output: %w", err)
+	}
+	return quarantined(verifyErr, quarantinePath)
+}
+
+// syncAndClose flushes file to disk and closes it
+func syncAndClose(file *os.File) error {
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync %s: %w", file.Name(), err)
+	}
+	return file.Close()
+}
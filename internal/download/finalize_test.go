@@ -0,0 +1,97 @@
+package download
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDownloadTruncatedKeepsOutputPathFree(t *testing.T) {
+	content := testContent(10000)
+
+	// The server promises the whole file but stops halfway
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+		if r.Method == "GET" {
+			w.Write(content[:5000])
+		}
+	}))
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "downloader_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	outputPath := filepath.Join(tempDir, "output.bin")
+	downloader := NewDownloader(server.URL, outputPath, 1)
+	downloader.SetVerbose(false)
+
+	if err := downloader.Start(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+		t.Error("Expected no file at the output path after a failed download")
+	}
+	if info, err := os.Stat(PartPath(outputPath)); err != nil || info.Size() != 5000 {
+		t.Errorf("Expected the 5000 received bytes in the part file, got %v", err)
+	}
+}
+
+func TestDownloadFinalize(t *testing.T) {
+	content := testContent(10000)
+	modified := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", modified, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	for _, threads := range []int{1, 4} {
+		t.Run(fmt.Sprintf("%d threads", threads), func(t *testing.T) {
+			tempDir, err := os.MkdirTemp("", "downloader_test")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			outputPath := filepath.Join(tempDir, "output.bin")
+			partPath := filepath.Join(tempDir, "incoming", "output.tmp")
+
+			downloader := NewDownloader(server.URL, outputPath, threads)
+			downloader.SetVerbose(false)
+			downloader.SetPartPath(partPath)
+			downloader.SetPreserveModTime(true)
+
+			if err := downloader.Start(); err != nil {
+				t.Fatalf("Download failed: %v", err)
+			}
+
+			info, err := os.Stat(outputPath)
+			if err != nil {
+				t.Fatalf("Failed to stat output: %v", err)
+			}
+			if info.Size() != int64(len(content)) {
+				t.Errorf("Expected %d bytes, got %d", len(content), info.Size())
+			}
+			if !info.ModTime().Equal(modified) {
+				t.Errorf("Expected modification time %v, got %v", modified, info.ModTime())
+			}
+
+			if _, err := os.Stat(partPath); !os.IsNotExist(err) {
+				t.Error("Expected the part file to be renamed")
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package download
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Mirror tracks the health of one URL serving the file
+type Mirror struct {
+	URL      string
+	Errors   int
+	Bytes    int64
+	Elapsed  time.Duration
+	Disabled bool
+}
+
+// Throughput returns the mirror's observed speed in bytes per second, or 0
+// if nothing has been downloaded from it yet
+func (m *Mirror) Throughput() float64 {
+	if m.Elapsed <= 0 {
+		return 0
+	}
+	return float64(m.Bytes) / m.Elapsed.Seconds()
+}
+
+// MirrorSet spreads chunks across several URLs for the same file. Each pick
+// is random, weighted by the mirror's throughput and penalised by its
+// errors, so slow or failing mirrors get fewer chunks.
+type MirrorSet struct {
+	mirrors []*Mirror
+	mu      sync.Mutex
+}
+
+// NewMirrorSet creates a set of mirrors for the given URLs
+func NewMirrorSet(urls []string) *MirrorSet {
+	set := &MirrorSet{}
+	for _, url := range urls {
+		set.mirrors = append(set.mirrors, &Mirror{URL: url})
+	}
+	return set
+}
+
+// Mirrors returns a snapshot of every mirror's health
+func (s *MirrorSet) Mirrors() []Mirror {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := make([]Mirror, len(s.mirrors))
+	for i, mirror := range s.mirrors {
+		snapshot[i] = *mirror
+	}
+	return snapshot
+}
+
+// Pick chooses a mirror URL for the next chunk, avoiding exclude (usually
+// the URL that just failed) when another mirror is available
+func (s *MirrorSet) Pick(exclude string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var candidates []*Mirror
+	for _, mirror := range s.mirrors {
+		if !mirror.Disabled && mirror.URL != exclude {
+			candidates = append(candidates, mirror)
+		}
+	}
+	if len(candidates) == 0 {
+		return exclude
+	}
+
+	weights := make([]float64, len(candidates))
+	var total float64
+	for i, mirror := range candidates {
+		weights[i] = s.score(mirror)
+		total += weights[i]
+	}
+
+	r := rand.Float64() * total
+	for i, weight := range weights {
+		r -= weight
+		if r < 0 {
+			return candidates[i].URL
+		}
+	}
+	return candidates[len(candidates)-1].URL
+}
+
+// score returns the mirror's weight. Untested mirrors are scored like the
+// fastest known one so they get tried. Must be called with s.mu held.
+func (s *MirrorSet) score(mirror *Mirror) float64 {
+	throughput := mirror.Throughput()
+	if throughput == 0 {
+		throughput = 1
+		for _, other := range s.mirrors {
+			throughput = max(throughput, other.Throughput())
+		}
+	}
+	return throughput / math.Pow(2, float64(mirror.Errors))
+}
+
+// ReportSuccess records a completed transfer from a mirror
+func (s *MirrorSet) ReportSuccess(url string, bytes int64, elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if mirror := s.find(url); mirror != nil {
+		mirror.Bytes += bytes
+		mirror.Elapsed += elapsed
+	}
+}
+
+// ReportFailure records a failed transfer from a mirror
+func (s *MirrorSet) ReportFailure(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if mirror := s.find(url); mirror != nil {
+		mirror.Errors++
+	}
+}
+
+// Disable stops a mirror from being picked because it serves a different
+// version of the file. It returns how many mirrors are still enabled.
+func (s *MirrorSet) Disable(url string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if mirror := s.find(url); mirror != nil {
+		mirror.Disabled = true
+	}
+
+	var enabled int
+	for _, mirror := range s.mirrors {
+		if !mirror.Disabled {
+			enabled++
+		}
+	}
+	return enabled
+}
+
+// HasOther reports whether a mirror other than url can still be picked
+func (s *MirrorSet) HasOther(url string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, mirror := range s.mirrors {
+		if !mirror.Disabled && mirror.URL != url {
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the mirror with the given URL. Must be called with s.mu held.
+func (s *MirrorSet) find(url string) *Mirror {
+	for _, mirror := range s.mirrors {
+		if mirror.URL == url {
+			return mirror
+		}
+	}
+	return nil
+}
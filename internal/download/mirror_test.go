@@ -0,0 +1,154 @@
+package download
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMirrorSetPick(t *testing.T) {
+	set := NewMirrorSet([]string{"https://a.example.com", "https://b.example.com"})
+
+	// The excluded mirror is avoided while another one is available
+	for range 20 {
+		if url := set.Pick("https://a.example.com"); url != "https://b.example.com" {
+			t.Fatalf("Expected mirror b, got %s", url)
+		}
+	}
+
+	// Disabled mirrors are never picked
+	set.Disable("https://b.example.com")
+	if url := set.Pick("https://a.example.com"); url != "https://a.example.com" {
+		t.Errorf("Expected fallback to the excluded mirror, got %s", url)
+	}
+}
+
+func TestMirrorSetFavoursHealthyMirrors(t *testing.T) {
+	set := NewMirrorSet([]string{"https://fast.example.com", "https://slow.example.com"})
+	set.ReportSuccess("https://fast.example.com", 100*1024*1024, time.Second)
+	set.ReportSuccess("https://slow.example.com", 1024*1024, time.Second)
+	set.ReportFailure("https://slow.example.com")
+
+	picks := make(map[string]int)
+	for range 1000 {
+		picks[set.Pick("")]++
+	}
+
+	if picks["https://fast.example.com"] < 900 {
+		t.Errorf("Expected the fast mirror to get most chunks, got %v", picks)
+	}
+
+	mirrors := set.Mirrors()
+	if mirrors[1].Errors != 1 {
+		t.Errorf("Expected 1 error for the slow mirror, got %d", mirrors[1].Errors)
+	}
+	if mirrors[0].Throughput() != 100*1024*1024 {
+		t.Errorf("Unexpected throughput for the fast mirror: %f", mirrors[0].Throughput())
+	}
+}
+
+func TestDownloadWithMirrors(t *testing.T) {
+	content := testContent(10000)
+
+	good, _ := setupContentServer(t, content)
+	defer good.Close()
+
+	// A mirror that reports the file but fails every chunk request
+	broken, _ := setupContentServer(t, content)
+	broken.Config.Handler = failingGets(broken.Config.Handler)
+	defer broken.Close()
+
+	// A mirror serving a different file is skipped
+	other, _ := setupContentServer(t, testContent(500))
+	defer other.Close()
+
+	tempDir, err := os.MkdirTemp("", "mirror_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	outputPath := filepath.Join(tempDir, "output.bin")
+	downloader := NewDownloader(broken.URL, outputPath, 4)
+	downloader.SetVerbose(false)
+	downloader.SetMirrors([]string{good.URL, other.URL})
+	downloader.SetRetryPolicy(fastRetryPolicy())
+
+	if err := downloader.Start(); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Output does not match the remote content")
+	}
+}
+
+func TestDownloadDisablesChangedMirror(t *testing.T) {
+	content := testContent(10000)
+
+	good, _ := setupContentServer(t, content)
+	defer good.Close()
+
+	// A mirror whose file changes after the probe
+	var changedGets atomic.Int32
+	changed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Method == "GET" {
+			changedGets.Add(1)
+			w.Header().Set("ETag", `"v2"`)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer changed.Close()
+
+	tempDir, err := os.MkdirTemp("", "mirror_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	outputPath := filepath.Join(tempDir, "output.bin")
+	downloader := NewDownloader(changed.URL, outputPath, 8)
+	downloader.SetVerbose(false)
+	downloader.SetMinSplitSize(0)
+	downloader.SetMirrors([]string{good.URL})
+	downloader.SetRetryPolicy(fastRetryPolicy())
+
+	if err := downloader.Start(); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Output does not match the remote content")
+	}
+
+	// Once disabled, the changed mirror only sees the requests already
+	// in flight
+	if gets := changedGets.Load(); gets > 8 {
+		t.Errorf("Expected the changed mirror to be disabled, got %d requests", gets)
+	}
+}
+
+// failingGets wraps a handler so that GET requests fail with 404
+func failingGets(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
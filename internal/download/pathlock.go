@@ -0,0 +1,78 @@
+package download
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+)
+
+// PathLocks keeps downloads running side by side from writing to the same
+// output path, which would make them share a part file and a resume state.
+// The zero value is ready to use.
+type PathLocks struct {
+	held map[string]chan struct{}
+	mu   sync.Mutex
+}
+
+// Lock waits until no other download holds path, then takes it. It returns
+// a function releasing the path, or the context's error if ctx is done
+// first.
+func (l *PathLocks) Lock(ctx context.Context, path string) (func(), error) {
+	for {
+		unlock, released := l.tryLock(path)
+		if unlock != nil {
+			return unlock, nil
+		}
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// TryLock takes path if no other download holds it. It returns a function
+// releasing the path and whether it was taken.
+func (l *PathLocks) TryLock(path string) (func(), bool) {
+	unlock, _ := l.tryLock(path)
+	return unlock, unlock != nil
+}
+
+// tryLock takes path if it is free. Otherwise it returns a channel closed
+// when the holder releases it.
+func (l *PathLocks) tryLock(path string) (func(), <-chan struct{}) {
+	key := lockKey(path)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if released, ok := l.held[key]; ok {
+		return nil, released
+	}
+	if l.held == nil {
+		l.held = make(map[string]chan struct{})
+	}
+
+	released := make(chan struct{})
+	l.held[key] = released
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+
+			delete(l.held, key)
+			close(released)
+		})
+	}, nil
+}
+
+// lockKey identifies a path however it was spelled
+func lockKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
@@ -0,0 +1,71 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPathLocks(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "pathlock_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	path := filepath.Join(tempDir, "file.zip")
+	var locks PathLocks
+
+	unlock, err := locks.Lock(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+
+	// The same path spelled differently is held too
+	if _, ok := locks.TryLock(filepath.Join(tempDir, ".", "file.zip")); ok {
+		t.Error("Expected TryLock of a held path to fail")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := locks.Lock(ctx, path); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected Lock to wait until the deadline, got %v", err)
+	}
+
+	// Another path is free
+	other, ok := locks.TryLock(filepath.Join(tempDir, "other.zip"))
+	if !ok {
+		t.Fatal("Expected TryLock of a free path to succeed")
+	}
+	other()
+
+	// A waiting Lock takes the path once it is released
+	locked := make(chan func())
+	go func() {
+		next, err := locks.Lock(context.Background(), path)
+		if err != nil {
+			t.Errorf("Lock failed: %v", err)
+		}
+		locked <- next
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("Expected Lock to wait while the path is held")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	unlock()
+	unlock()
+	next := <-locked
+	if _, ok := locks.TryLock(path); ok {
+		t.Error("Expected the path to be held by the waiting Lock")
+	}
+	next()
+
+	if _, ok := locks.TryLock(path); !ok {
+		t.Error("Expected the path to be free again")
+	}
+}
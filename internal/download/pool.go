@@ -0,0 +1,262 @@
+package download
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/godownloader/internal/utils"
+)
+
+// DefaultMinSplitSize is the smallest range handed to an idle worker when
+// splitting an in-flight chunk
+const DefaultMinSplitSize = 1024 * 1024 // 1MB
+
+// WorkerPool downloads chunks on a fixed number of workers. If MinSplitSize
+// is positive, a worker that goes idle while others are still busy is given
+// the second half of the largest remaining range of an in-flight chunk, so
+// one slow connection doesn't hold up the whole download. A chunk that
+// fails in a way RetryPolicy retries, or that another mirror can serve, is
+// queued again on the same workers after the policy's delay, up to
+// MaxRetries times.
+type WorkerPool struct {
+	NumWorkers   int
+	MinSplitSize int64
+	MaxRetries   int
+
+	// Mirrors, if set, picks the URL each chunk is downloaded from
+	Mirrors *MirrorSet
+
+	// Limiters throttle all workers of the pool together
+	Limiters []*utils.RateLimiter
+
+	// RetryPolicy controls retries of each request; nil means the default
+	RetryPolicy *utils.RetryPolicy
+
+	// Request adds headers, credentials and cookies to every request
+	Request *utils.RequestOptions
+
+	// Client, if set, sends the requests of all workers
+	Client *http.Client
+
+	// Timeouts abort responses that stall or arrive too slowly
+	Timeouts utils.Timeouts
+
+	// IfRange and ETag identify the version of the file being downloaded.
+	// If a worker finds the file has changed, the whole run is stopped.
+	IfRange string
+	ETag    string
+
+	// OnSplit is called with every chunk created by splitting
+	OnSplit func(chunk *Chunk)
+
+	// OnRetry is called with every failed chunk queued again and its error
+	OnRetry func(chunk *Chunk, err error)
+
+	chunks []*Chunk
+	nextID int
+	mu     sync.Mutex
+}
+
+// NewWorkerPool creates a pool for the given chunks with splitting disabled
+func NewWorkerPool(numWorkers int, chunks []*Chunk) *WorkerPool {
+	nextID := 0
+	for _, chunk := range chunks {
+		nextID = max(nextID, chunk.ID+1)
+	}
+
+	return &WorkerPool{
+		NumWorkers: max(numWorkers, 1),
+		chunks:     slices.Clone(chunks),
+		nextID:     nextID,
+	}
+}
+
+// Chunks returns all chunks of the pool, including split ones, ordered by Start
+func (p *WorkerPool) Chunks() []*Chunk {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return slices.Clone(p.chunks)
+}
+
+// Run downloads all chunks and returns one result per chunk, holding the
+// error of its last attempt if it failed. If ctx is done, the remaining
+// chunks fail and the context's error is returned.
+func (p *WorkerPool) Run(ctx context.Context) ([]*Result, error) {
+	chunks := p.Chunks()
+
+	// A changed file makes every other chunk useless, so it stops all workers
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// Splitting only enqueues while the queue is empty, so this never blocks
+	var wg sync.WaitGroup
+	jobQueue := make(chan *Chunk, len(chunks)+p.NumWorkers)
+	results := make(chan *Result, len(chunks)+p.NumWorkers)
+
+	// Create and start workers
+	for i := 0; i < p.NumWorkers; i++ {
+		worker := NewWorker(i, jobQueue, results, &wg)
+		worker.Mirrors = p.Mirrors
+		worker.Limiters = p.Limiters
+		worker.RetryPolicy = p.RetryPolicy
+		worker.Request = p.Request
+		if p.Client != nil {
+			worker.Client = p.Client
+		}
+		worker.Timeouts = p.Timeouts
+		worker.IfRange = p.IfRange
+		worker.ETag = p.ETag
+		worker.StartContext(ctx)
+	}
+
+	// Chunks queued or being downloaded
+	outstanding := make(map[*Chunk]bool)
+	enqueue := func(chunk *Chunk) {
+		outstanding[chunk] = true
+		wg.Add(1)
+		jobQueue <- chunk
+	}
+
+	for _, chunk := range chunks {
+		enqueue(chunk)
+	}
+	p.fillIdleWorkers(ctx, outstanding, jobQueue, enqueue)
+
+	// Collect results, handing work to workers as they go idle. A requeued
+	// chunk takes the place it left, so the queue never fills up.
+	var downloadResults []*Result
+	for len(outstanding) > 0 {
+		result := <-results
+		delete(outstanding, result.Chunk)
+
+		if errors.Is(result.Error, ErrResourceChanged) {
+			cancel(result.Error)
+		}
+
+		if p.retryable(ctx, result) {
+			if p.OnRetry != nil {
+				p.OnRetry(result.Chunk, result.Error)
+			}
+			result.Chunk.ResetForRetry()
+			p.requeue(ctx, result.Chunk, outstanding, jobQueue, &wg)
+			continue
+		}
+		downloadResults = append(downloadResults, result)
+
+		p.fillIdleWorkers(ctx, outstanding, jobQueue, enqueue)
+	}
+
+	close(jobQueue)
+
+	if ctx.Err() != nil {
+		return downloadResults, context.Cause(ctx)
+	}
+
+	return downloadResults, nil
+}
+
+// ResultErrors returns the errors of all failed chunks among results,
+// joined, or nil if none failed
+func ResultErrors(results []*Result) error {
+	var errs []error
+	for _, result := range results {
+		if result.Error != nil {
+			errs = append(errs, fmt.Errorf("chunk %d failed: %w", result.Chunk.ID, result.Error))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// retryable reports whether the chunk of a result failed and may be queued
+// again: either the retry policy allows the error, or another mirror can
+// serve the chunk instead of the one that failed
+func (p *WorkerPool) retryable(ctx context.Context, result *Result) bool {
+	if result.Error == nil || ctx.Err() != nil {
+		return false
+	}
+	if !p.retryPolicy().Retryable(ctx, nil, result.Error) && (p.Mirrors == nil || !p.Mirrors.HasOther(result.Chunk.URL)) {
+		return false
+	}
+	return result.Chunk.State().RetryCount <= p.MaxRetries
+}
+
+// requeue queues a failed chunk again once the retry policy's delay has
+// passed. The chunk stays outstanding while it waits, so the run doesn't
+// end without it; if ctx is done it is queued at once and fails quickly.
+func (p *WorkerPool) requeue(ctx context.Context, chunk *Chunk, outstanding map[*Chunk]bool, jobQueue chan<- *Chunk, wg *sync.WaitGroup) {
+	outstanding[chunk] = true
+	wg.Add(1)
+
+	delay := p.retryPolicy().Delay(chunk.State().RetryCount, nil)
+	go func() {
+		utils.Sleep(ctx, delay)
+		jobQueue <- chunk
+	}()
+}
+
+// retryPolicy returns the pool's retry policy or the default one
+func (p *WorkerPool) retryPolicy() *utils.RetryPolicy {
+	if p.RetryPolicy != nil {
+		return p.RetryPolicy
+	}
+	return utils.DefaultRetryPolicy()
+}
+
+// fillIdleWorkers splits in-flight chunks while the queue is empty and some
+// workers have nothing to do
+func (p *WorkerPool) fillIdleWorkers(ctx context.Context, outstanding map[*Chunk]bool, jobQueue chan *Chunk, enqueue func(*Chunk)) {
+	if p.MinSplitSize <= 0 {
+		return
+	}
+
+	for ctx.Err() == nil && len(jobQueue) == 0 && len(outstanding) < p.NumWorkers {
+		tail := p.splitLargest(outstanding)
+		if tail == nil {
+			return
+		}
+		enqueue(tail)
+	}
+}
+
+// splitLargest splits the outstanding chunk with the most bytes remaining
+// and records the new chunk. It returns nil if no chunk is worth splitting.
+func (p *WorkerPool) splitLargest(outstanding map[*Chunk]bool) *Chunk {
+	var largest *Chunk
+	var largestRemaining int64
+	for chunk := range outstanding {
+		if remaining := chunk.Remaining(); remaining > largestRemaining {
+			largest, largestRemaining = chunk, remaining
+		}
+	}
+
+	if largest == nil {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tail := largest.Split(p.nextID, p.MinSplitSize)
+	if tail == nil {
+		return nil
+	}
+	p.nextID++
+
+	// Keep chunks ordered by Start for merging
+	i, _ := slices.BinarySearchFunc(p.chunks, tail.Start, func(c *Chunk, start int64) int {
+		return cmp.Compare(c.Start, start)
+	})
+	p.chunks = slices.Insert(p.chunks, i, tail)
+
+	if p.OnSplit != nil {
+		p.OnSplit(tail)
+	}
+
+	return tail
+}
@@ -0,0 +1,293 @@
+package download
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godownloader/internal/utils"
+)
+
+// setupSlowStartServer serves content with range support, sending ranges
+// that start at offset 0 slowly
+func setupSlowStartServer(t *testing.T, content []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int64
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+		w.WriteHeader(http.StatusPartialContent)
+
+		data := content[start : end+1]
+		if start != 0 {
+			w.Write(data)
+			return
+		}
+
+		// Trickle the first range until the client stops reading
+		for len(data) > 0 {
+			n := min(len(data), 100)
+			if _, err := w.Write(data[:n]); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			data = data[n:]
+			time.Sleep(5 * time.Millisecond)
+		}
+	}))
+}
+
+func TestWorkerPoolSplitsSlowChunk(t *testing.T) {
+	content := testContent(20000)
+	server := setupSlowStartServer(t, content)
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "pool_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	chunks, err := CalculateChunks(server.URL, int64(len(content)), 2, tempDir)
+	if err != nil {
+		t.Fatalf("CalculateChunks failed: %v", err)
+	}
+
+	var splits []*Chunk
+	pool := NewWorkerPool(2, chunks)
+	pool.MinSplitSize = 500
+	pool.OnSplit = func(chunk *Chunk) {
+		splits = append(splits, chunk)
+	}
+
+	results, err := pool.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	for _, result := range results {
+		if result.Error != nil {
+			t.Fatalf("Chunk %d failed: %v", result.Chunk.ID, result.Error)
+		}
+	}
+
+	if len(splits) == 0 {
+		t.Fatal("Expected the slow chunk to be split")
+	}
+
+	// Every chunk produced a result and the chunks still tile the file
+	all := pool.Chunks()
+	if len(results) != len(all) {
+		t.Errorf("Expected %d results, got %d", len(all), len(results))
+	}
+
+	var merged []byte
+	var next int64
+	for _, chunk := range all {
+		if chunk.Start != next {
+			t.Fatalf("Expected chunk %d to start at %d, got %d", chunk.ID, next, chunk.Start)
+		}
+		next = chunk.End + 1
+
+		data, err := os.ReadFile(chunk.TempFile)
+		if err != nil {
+			t.Fatalf("Failed to read chunk %d: %v", chunk.ID, err)
+		}
+		merged = append(merged, data...)
+	}
+
+	if !bytes.Equal(merged, content) {
+		t.Error("Split chunks do not reassemble the content")
+	}
+}
+
+func TestWorkerPoolWithoutSplitting(t *testing.T) {
+	content := testContent(1000)
+	server, _ := setupContentServer(t, content)
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "pool_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	chunks, err := CalculateChunks(server.URL, int64(len(content)), 2, tempDir)
+	if err != nil {
+		t.Fatalf("CalculateChunks failed: %v", err)
+	}
+
+	// More workers than chunks, but splitting is disabled by default
+	pool := NewWorkerPool(4, chunks)
+	results, err := pool.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if len(results) != 2 || len(pool.Chunks()) != 2 {
+		t.Errorf("Expected 2 results and chunks, got %d and %d", len(results), len(pool.Chunks()))
+	}
+}
+
+func TestWorkerPoolRequeuesFailedChunks(t *testing.T) {
+	content := testContent(10000)
+
+	tests := []struct {
+		name   string
+		status int
+		// failures is how many requests of each range fail before it is served
+		failures   int
+		maxRetries int
+		// wantRetries is how many times each chunk is queued again
+		wantRetries int
+		wantStatus  int
+	}{
+		{name: "recovers", status: http.StatusServiceUnavailable, failures: 2, maxRetries: 2, wantRetries: 2},
+		{name: "exhausted", status: http.StatusServiceUnavailable, failures: 3, maxRetries: 2, wantRetries: 2, wantStatus: http.StatusServiceUnavailable},
+		{name: "not retryable", status: http.StatusNotFound, failures: 1, maxRetries: 2, wantRetries: 0, wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			attempts := make(map[string]int)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				rangeHeader := r.Header.Get("Range")
+				mu.Lock()
+				attempts[rangeHeader]++
+				fail := attempts[rangeHeader] <= tt.failures
+				mu.Unlock()
+
+				if fail {
+					w.WriteHeader(tt.status)
+					return
+				}
+				http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+			}))
+			defer server.Close()
+
+			tempDir, err := os.MkdirTemp("", "pool_test")
+			if err != nil {
+				t.Fatalf("Failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			chunks, err := CalculateChunks(server.URL, int64(len(content)), 2, tempDir)
+			if err != nil {
+				t.Fatalf("CalculateChunks failed: %v", err)
+			}
+
+			var retries int
+			pool := NewWorkerPool(2, chunks)
+			pool.MaxRetries = tt.maxRetries
+			// Every request is tried once, so every failure fails the chunk
+			pool.RetryPolicy = &utils.RetryPolicy{
+				MaxAttempts:     1,
+				BaseDelay:       time.Millisecond,
+				RetryableStatus: utils.DefaultRetryableStatus,
+			}
+			pool.OnRetry = func(chunk *Chunk, err error) {
+				retries++
+			}
+
+			results, err := pool.Run(context.Background())
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if len(results) != len(chunks) {
+				t.Fatalf("Expected one result per chunk, got %d", len(results))
+			}
+
+			if want := len(chunks) * tt.wantRetries; retries != want {
+				t.Errorf("Expected %d retries, got %d", want, retries)
+			}
+			for rangeHeader, n := range attempts {
+				if want := tt.wantRetries + 1; n != want {
+					t.Errorf("Expected %d requests of %s, got %d", want, rangeHeader, n)
+				}
+			}
+
+			err = ResultErrors(results)
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Errorf("Expected every chunk to succeed, got %v", err)
+				}
+				return
+			}
+
+			// Both chunks are reported, not just the first
+			var status *utils.StatusError
+			if !errors.As(err, &status) || status.StatusCode != tt.wantStatus {
+				t.Errorf("Expected a %d status error, got %v", tt.wantStatus, err)
+			}
+			for _, chunk := range chunks {
+				if !strings.Contains(err.Error(), fmt.Sprintf("chunk %d failed", chunk.ID)) {
+					t.Errorf("Expected the error of chunk %d in %q", chunk.ID, err)
+				}
+			}
+		})
+	}
+}
+
+func TestWorkerPoolWaitsBeforeRequeue(t *testing.T) {
+	content := testContent(1000)
+
+	var mu sync.Mutex
+	var times []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		times = append(times, time.Now())
+		fail := len(times) == 1
+		mu.Unlock()
+
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "pool_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	chunks, err := CalculateChunks(server.URL, int64(len(content)), 1, tempDir)
+	if err != nil {
+		t.Fatalf("CalculateChunks failed: %v", err)
+	}
+
+	delay := 100 * time.Millisecond
+	pool := NewWorkerPool(1, chunks)
+	pool.MaxRetries = 1
+	pool.RetryPolicy = &utils.RetryPolicy{
+		MaxAttempts:     1,
+		BaseDelay:       delay,
+		RetryableStatus: utils.DefaultRetryableStatus,
+	}
+
+	results, err := pool.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if err := ResultErrors(results); err != nil {
+		t.Fatalf("Expected the chunk to succeed, got %v", err)
+	}
+
+	if len(times) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(times))
+	}
+	if waited := times[1].Sub(times[0]); waited < delay {
+		t.Errorf("Expected the requeue to wait %v, waited %v", delay, waited)
+	}
+}
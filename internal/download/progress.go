@@ -6,7 +6,8 @@ import (
 	"time"
 )
 
-// Progress tracks the download progress
+// Progress tracks the download progress. A negative TotalSize means the
+// size is unknown, in which case there is no percentage or ETA.
 type Progress struct {
 	TotalSize       int64
 	Downloaded      int64
@@ -18,11 +19,20 @@ type Progress struct {
 	ProgressPercent float64
 	Chunks          []*Chunk
 	SpeedSamples    []float64
-	mu              sync.Mutex
+	ResumedBytes    int64
+	// OnUpdate, if set, is called after every periodic update
+	OnUpdate func()
+	mu       sync.Mutex
 }
 
 // NewProgress creates a new progress tracker
 func NewProgress(totalSize int64, chunks []*Chunk) *Progress {
+	// Bytes restored from a previous run don't count towards the speed
+	var resumed int64
+	for _, chunk := range chunks {
+		resumed += chunk.Downloaded
+	}
+
 	return &Progress{
 		TotalSize:    totalSize,
 		Downloaded:   0,
@@ -31,6 +41,7 @@ func NewProgress(totalSize int64, chunks []*Chunk) *Progress {
 		AverageSpeed: 0,
 		Chunks:       chunks,
 		SpeedSamples: make([]float64, 0, 10),
+		ResumedBytes: resumed,
 		mu:           sync.Mutex{},
 	}
 }
@@ -43,7 +54,7 @@ func (p *Progress) Update() {
 	var downloaded int64
 	if p.Chunks != nil {
 		for _, chunk := range p.Chunks {
-			downloaded += chunk.Downloaded
+			downloaded += chunk.Record().Downloaded
 		}
 	} else {
 		downloaded = p.Downloaded
@@ -52,7 +63,7 @@ func (p *Progress) Update() {
 	// Calculate speed
 	elapsed := time.Since(p.StartTime).Seconds()
 	if elapsed > 0 {
-		currentSpeed := float64(downloaded) / elapsed
+		currentSpeed := float64(downloaded-p.ResumedBytes) / elapsed
 		p.SpeedSamples = append(p.SpeedSamples, currentSpeed)
 
 		// Keep only last 10 samples
@@ -68,8 +79,8 @@ func (p *Progress) Update() {
 		p.AverageSpeed = totalSpeed / float64(len(p.SpeedSamples))
 		p.CurrentSpeed = currentSpeed
 
-		// Calculate ETA
-		if p.AverageSpeed > 0 {
+		// Calculate ETA, unless the total size is unknown
+		if p.AverageSpeed > 0 && p.TotalSize > 0 {
 			remaining := float64(p.TotalSize - downloaded)
 			etaSeconds := remaining / p.AverageSpeed
 			p.ETA = time.Duration(etaSeconds) * time.Second
@@ -80,17 +91,70 @@ func (p *Progress) Update() {
 
 	// Calculate percentage
 	if p.TotalSize > 0 {
-		p.ProgressPercent = float64(downloaded) * 100 / float64(p.TotalSize)
+		p.ProgressPercent = percent(downloaded, p.TotalSize)
 	}
 
 	// Create progress bar
 	p.createProgressBar()
 }
 
+// AddChunk starts tracking a chunk created after the tracker, such as one
+// split off an in-flight chunk
+func (p *Progress) AddChunk(chunk *Chunk) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Chunks = append(p.Chunks, chunk)
+}
+
+// SetDownloaded records the bytes downloaded by a download without chunks
+func (p *Progress) SetDownloaded(downloaded int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.Downloaded = downloaded
+}
+
+// Snapshot returns the progress as of the last update as an event of the
+// given type
+func (p *Progress) Snapshot(eventType EventType) ProgressEvent {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	event := ProgressEvent{
+		Type:       eventType,
+		TotalSize:  p.TotalSize,
+		Downloaded: p.Downloaded,
+		Speed:      p.CurrentSpeed,
+		ETA:        p.ETA,
+		Elapsed:    time.Since(p.StartTime),
+	}
+	for _, chunk := range p.Chunks {
+		event.Chunks = append(event.Chunks, chunk.State())
+	}
+	return event
+}
+
 // createProgressBar generates a text-based progress bar
 func (p *Progress) createProgressBar() {
+	p.ProgressBar = progressBar(p.Downloaded, p.TotalSize)
+}
+
+// percent returns downloaded as a percentage of total
+func percent(downloaded, total int64) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(downloaded) * 100 / float64(total)
+}
+
+// progressBar renders a 50 character text progress bar
+func progressBar(downloaded, total int64) string {
 	width := 50
-	completed := int(float64(width) * float64(p.Downloaded) / float64(p.TotalSize))
+	completed := 0
+	if total > 0 {
+		completed = int(float64(width) * float64(downloaded) / float64(total))
+	}
 
 	bar := "["
 	for i := range width {
@@ -104,7 +168,7 @@ func (p *Progress) createProgressBar() {
 	}
 	bar += "]"
 
-	p.ProgressBar = bar
+	return bar
 }
 
 // Print displays the current progress
@@ -123,7 +187,8 @@ func (p *Progress) Print() {
 	)
 }
 
-// StartTracking begins tracking and displaying progress
+// StartTracking updates the progress periodically, calling OnUpdate after
+// each update, until stopChan is closed
 func (p *Progress) StartTracking(updateInterval time.Duration, stopChan <-chan struct{}) {
 	ticker := time.NewTicker(updateInterval)
 	defer ticker.Stop()
@@ -131,17 +196,22 @@ func (p *Progress) StartTracking(updateInterval time.Duration, stopChan <-chan s
 	for {
 		select {
 		case <-ticker.C:
-			p.Update()
-			p.Print()
+			p.update()
 		case <-stopChan:
-			p.Update()
-			p.Print()
-			fmt.Println() // Add a newline after the progress bar
+			p.update()
 			return
 		}
 	}
 }
 
+// update refreshes the progress and notifies OnUpdate
+func (p *Progress) update() {
+	p.Update()
+	if p.OnUpdate != nil {
+		p.OnUpdate()
+	}
+}
+
 // PrintSummary prints a summary of the download
 func (p *Progress) PrintSummary() {
 	p.mu.Lock()
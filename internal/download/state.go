@@ -0,0 +1,172 @@
+package download
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+const (
+	// StateSuffix is appended to the output path to name the resume state file
+	StateSuffix = ".gdl-state"
+
+	// PartsSuffix is appended to the output path to name the chunk directory
+	PartsSuffix = ".gdl-parts"
+
+	// PartSuffix is appended to the output path to name the file written
+	// until the download is complete
+	PartSuffix = ".part"
+
+	// ETagSuffix is appended to the output path to name the file recording
+	// the ETag of a finished download, which ExistsSkip compares
+	ETagSuffix = ".gdl-etag"
+
+	stateVersion = 1
+)
+
+// State is the persisted progress of an interrupted download
+type State struct {
+	Version       int           `json:"version"`
+	URL           string        `json:"url"`
+	ContentLength int64         `json:"content_length"`
+	ETag          string        `json:"etag,omitempty"`
+	LastModified  string        `json:"last_modified,omitempty"`
+	Preallocated  bool          `json:"preallocated,omitempty"`
+	Chunks        []ChunkRecord `json:"chunks"`
+}
+
+// ChunkRecord is the persisted form of a chunk
+type ChunkRecord struct {
+	ID         int   `json:"id"`
+	Start      int64 `json:"start"`
+	End        int64 `json:"end"`
+	Downloaded int64 `json:"downloaded"`
+}
+
+// StatePath returns the path of the state file for an output path
+func StatePath(outputPath string) string {
+	return outputPath + StateSuffix
+}
+
+// PartsDir returns the directory holding chunk files for an output path
+func PartsDir(outputPath string) string {
+	return outputPath + PartsSuffix
+}
+
+// PartPath returns where the file is written until the download is complete
+func PartPath(outputPath string) string {
+	return outputPath + PartSuffix
+}
+
+// ETagPath returns where the ETag of the finished file at an output path
+// is recorded
+func ETagPath(outputPath string) string {
+	return outputPath + ETagSuffix
+}
+
+// LoadState reads a state file. It returns an error wrapping os.ErrNotExist
+// when there is nothing to resume.
+func LoadState(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse state file: %w", err)
+	}
+
+	if state.Version != stateVersion {
+		return nil, fmt.Errorf("unsupported state file version: %d", state.Version)
+	}
+
+	return &state, nil
+}
+
+// Save writes the state file atomically so a crash never leaves it truncated
+func (s *State) Save(path string) error {
+	s.Version = stateVersion
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace state file: %w", err)
+	}
+
+	return nil
+}
+
+// Matches reports whether the state describes the same remote resource
+func (s *State) Matches(url string, contentLength int64, etag, lastModified string) bool {
+	return s.URL == url &&
+		s.ContentLength == contentLength &&
+		s.ETag == etag &&
+		s.LastModified == lastModified
+}
+
+// RestoreChunks rebuilds chunks from the state. For chunk files the
+// downloaded byte count is taken from the size of the partial file on disk;
+// for a preallocated output the recorded count is used.
+func (s *State) RestoreChunks(tempDir string) ([]*Chunk, error) {
+	if len(s.Chunks) == 0 {
+		return nil, errors.New("state file has no chunks")
+	}
+
+	chunks := make([]*Chunk, len(s.Chunks))
+	for i, record := range s.Chunks {
+		if record.Start > record.End || record.End >= s.ContentLength {
+			return nil, fmt.Errorf("invalid chunk %d in state file", record.ID)
+		}
+
+		chunk := NewChunk(record.ID, s.URL, record.Start, record.End, tempDir)
+
+		if s.Preallocated {
+			// Bytes are written before progress is recorded, so the saved
+			// count never covers bytes missing from the output
+			chunk.Downloaded = min(max(record.Downloaded, 0), chunk.Size)
+		} else if info, err := os.Stat(chunk.TempFile); err == nil {
+			// The file is authoritative and may be ahead of the saved count
+			chunk.Downloaded = min(info.Size(), chunk.Size)
+		}
+		chunk.Completed = chunk.Downloaded == chunk.Size
+
+		chunks[i] = chunk
+	}
+
+	return chunks, nil
+}
+
+// NewState captures the current progress of the given chunks
+func NewState(url string, contentLength int64, etag, lastModified string, chunks []*Chunk) *State {
+	records := make([]ChunkRecord, len(chunks))
+	for i, chunk := range chunks {
+		records[i] = chunk.Record()
+	}
+
+	return &State{
+		URL:           url,
+		ContentLength: contentLength,
+		ETag:          etag,
+		LastModified:  lastModified,
+		Chunks:        records,
+	}
+}
+
+// RemoveState deletes the state file and chunk directory for an output path
+func RemoveState(outputPath string) error {
+	if err := os.Remove(StatePath(outputPath)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.RemoveAll(PartsDir(outputPath))
+}
@@ -0,0 +1,181 @@
+package download
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateSaveAndLoad(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "state_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	chunks := []*Chunk{
+		NewChunk(0, "https://example.com/test.zip", 0, 499, tempDir),
+		NewChunk(1, "https://example.com/test.zip", 500, 999, tempDir),
+	}
+	chunks[1].Downloaded = 200
+
+	state := NewState("https://example.com/test.zip", 1000, `"v1"`, "", chunks)
+	path := StatePath(filepath.Join(tempDir, "test.zip"))
+	if err := state.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := LoadState(path)
+	if err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+
+	if !loaded.Matches("https://example.com/test.zip", 1000, `"v1"`, "") {
+		t.Error("Expected loaded state to match the resource")
+	}
+
+	if loaded.Matches("https://example.com/test.zip", 1000, `"v2"`, "") {
+		t.Error("Expected state not to match a different ETag")
+	}
+
+	if len(loaded.Chunks) != 2 || loaded.Chunks[1].Downloaded != 200 {
+		t.Errorf("Unexpected chunks in loaded state: %+v", loaded.Chunks)
+	}
+
+	// Missing state file
+	_, err = LoadState(filepath.Join(tempDir, "missing"+StateSuffix))
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, got %v", err)
+	}
+}
+
+func TestRestoreChunks(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "state_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	state := &State{
+		URL:           "https://example.com/test.zip",
+		ContentLength: 1000,
+		Chunks: []ChunkRecord{
+			{ID: 0, Start: 0, End: 499, Downloaded: 100},
+			{ID: 1, Start: 500, End: 999, Downloaded: 0},
+		},
+	}
+
+	// The first chunk is complete on disk even though the state lags behind
+	if err := os.WriteFile(filepath.Join(tempDir, "chunk_0"), make([]byte, 500), 0644); err != nil {
+		t.Fatalf("Failed to write chunk file: %v", err)
+	}
+
+	chunks, err := state.RestoreChunks(tempDir)
+	if err != nil {
+		t.Fatalf("RestoreChunks failed: %v", err)
+	}
+
+	if chunks[0].Downloaded != 500 || !chunks[0].Completed {
+		t.Errorf("Expected chunk 0 to be complete, got Downloaded=%d Completed=%v", chunks[0].Downloaded, chunks[0].Completed)
+	}
+
+	if chunks[1].Downloaded != 0 || chunks[1].Completed {
+		t.Errorf("Expected chunk 1 to be empty, got Downloaded=%d Completed=%v", chunks[1].Downloaded, chunks[1].Completed)
+	}
+
+	// Chunks outside the file are rejected
+	state.Chunks[1].End = 1000
+	if _, err := state.RestoreChunks(tempDir); err == nil {
+		t.Error("Expected error for chunk beyond content length")
+	}
+}
+
+func TestResumeMultiThreaded(t *testing.T) {
+	content := testContent(1000)
+
+	server, ranges := setupContentServer(t, content)
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "resume_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	outputPath := filepath.Join(tempDir, "output.bin")
+
+	// Simulate an interrupted run: chunk 0 complete, chunk 1 half done
+	partsDir := PartsDir(outputPath)
+	if err := os.MkdirAll(partsDir, 0755); err != nil {
+		t.Fatalf("Failed to create parts dir: %v", err)
+	}
+	os.WriteFile(filepath.Join(partsDir, "chunk_0"), content[:500], 0644)
+	os.WriteFile(filepath.Join(partsDir, "chunk_1"), content[500:750], 0644)
+
+	state := &State{
+		URL:           server.URL,
+		ContentLength: int64(len(content)),
+		ETag:          `"v1"`,
+		Chunks: []ChunkRecord{
+			{ID: 0, Start: 0, End: 499, Downloaded: 500},
+			{ID: 1, Start: 500, End: 999, Downloaded: 250},
+		},
+	}
+	if err := state.Save(StatePath(outputPath)); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	downloader := NewDownloader(server.URL, outputPath, 2)
+	downloader.SetVerbose(false)
+	if err := downloader.Start(); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Resumed output does not match the remote content")
+	}
+
+	if got := ranges(); len(got) != 1 || got[0] != "bytes=750-999" {
+		t.Errorf("Expected a single request for the missing bytes, got %v", got)
+	}
+
+	// State and chunks are removed after a successful download
+	if _, err := os.Stat(StatePath(outputPath)); !os.IsNotExist(err) {
+		t.Error("Expected state file to be removed")
+	}
+	if _, err := os.Stat(partsDir); !os.IsNotExist(err) {
+		t.Error("Expected parts directory to be removed")
+	}
+}
+
+func TestRestoreChunksPreallocated(t *testing.T) {
+	state := &State{
+		URL:           "https://example.com/test.zip",
+		ContentLength: 1000,
+		Preallocated:  true,
+		Chunks: []ChunkRecord{
+			{ID: 0, Start: 0, End: 499, Downloaded: 500},
+			{ID: 1, Start: 500, End: 999, Downloaded: 120},
+		},
+	}
+
+	// No chunk files exist; the recorded counts are used
+	chunks, err := state.RestoreChunks("/nonexistent")
+	if err != nil {
+		t.Fatalf("RestoreChunks failed: %v", err)
+	}
+
+	if !chunks[0].Completed {
+		t.Error("Expected chunk 0 to be complete")
+	}
+
+	if chunks[1].Downloaded != 120 || chunks[1].Completed {
+		t.Errorf("Expected chunk 1 to have 120 bytes, got %d", chunks[1].Downloaded)
+	}
+}
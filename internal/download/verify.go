@@ -0,0 +1,125 @@
+package download
+
+import (
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+	"time"
+)
+
+// QuarantineSuffix is appended to the output path of a file that failed
+// checksum verification
+const QuarantineSuffix = ".quarantine"
+
+// QuarantinePath returns where a file that failed verification is kept
+func QuarantinePath(outputPath string) string {
+	return outputPath + QuarantineSuffix
+}
+
+// completedPrefix returns how many bytes from the start of the file are
+// covered by completed chunks without a gap. Unlike the partial data of an
+// unfinished chunk, which a failed retry may discard, these bytes are final.
+// Chunks must be ordered by Start.
+func completedPrefix(chunks []*Chunk) int64 {
+	var pos int64
+	for _, chunk := range chunks {
+		record := chunk.Record()
+		if record.Start != pos || record.Downloaded < record.End-record.Start+1 {
+			break
+		}
+		pos = record.End + 1
+	}
+	return pos
+}
+
+// prefixHasher hashes a preallocated file in order while it is still being
+// downloaded. It trails the completed chunks at the start of the file, so
+// that part is hashed while the rest downloads; Finish hashes the remainder.
+type prefixHasher struct {
+	hash   hash.Hash
+	src    io.ReaderAt
+	hashed int64
+	buffer []byte
+	err    error
+
+	tracking bool
+	stopChan chan struct{}
+	doneChan chan struct{}
+	stopOnce sync.Once
+}
+
+// newPrefixHasher creates a hasher reading already written bytes from src
+func newPrefixHasher(h hash.Hash, src io.ReaderAt) *prefixHasher {
+	return &prefixHasher{
+		hash:     h,
+		src:      src,
+		buffer:   make([]byte, 32*1024),
+		stopChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+	}
+}
+
+// advance hashes the bytes between the current position and limit
+func (h *prefixHasher) advance(limit int64) error {
+	if h.err != nil || limit <= h.hashed {
+		return h.err
+	}
+
+	section := io.NewSectionReader(h.src, h.hashed, limit-h.hashed)
+	n, err := io.CopyBuffer(h.hash, section, h.buffer)
+	h.hashed += n
+	if err != nil {
+		h.err = fmt.Errorf("failed to hash downloaded data: %w", err)
+	}
+	return h.err
+}
+
+// Track hashes the completed prefix of the chunks in the background until
+// Finish is called
+func (h *prefixHasher) Track(interval time.Duration, chunks func() []*Chunk) {
+	h.tracking = true
+	go func() {
+		defer close(h.doneChan)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if h.advance(completedPrefix(chunks())) != nil {
+					return
+				}
+			case <-h.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends background tracking. It is safe to call more than once.
+func (h *prefixHasher) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.stopChan)
+		if h.tracking {
+			<-h.doneChan
+		}
+	})
+}
+
+// Finish stops tracking, hashes any remaining bytes up to size and returns
+// the hex digest
+func (h *prefixHasher) Finish(size int64) (string, error) {
+	h.Stop()
+
+	if err := h.advance(size); err != nil {
+		return "", err
+	}
+	if h.hashed != size {
+		return "", fmt.Errorf("hashed %d of %d bytes", h.hashed, size)
+	}
+
+	return hex.EncodeToString(h.hash.Sum(nil)), nil
+}
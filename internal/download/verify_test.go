@@ -0,0 +1,71 @@
+package download
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompletedPrefix(t *testing.T) {
+	chunks := []*Chunk{
+		NewChunk(0, "https://example.com/test.zip", 0, 99, "/tmp"),
+		NewChunk(1, "https://example.com/test.zip", 100, 199, "/tmp"),
+		NewChunk(2, "https://example.com/test.zip", 200, 299, "/tmp"),
+	}
+
+	// The partial bytes of an unfinished chunk don't count
+	chunks[0].Downloaded = 100
+	chunks[1].Downloaded = 40
+	chunks[2].Downloaded = 100
+	if prefix := completedPrefix(chunks); prefix != 100 {
+		t.Errorf("Expected prefix 100, got %d", prefix)
+	}
+
+	chunks[1].Downloaded = 100
+	if prefix := completedPrefix(chunks); prefix != 300 {
+		t.Errorf("Expected prefix 300, got %d", prefix)
+	}
+}
+
+func TestPrefixHasher(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "verify_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	content := testContent(300)
+	file, err := os.Create(filepath.Join(tempDir, "output.bin"))
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.Write(content); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	hasher := newPrefixHasher(sha256.New(), file)
+
+	// Hash part of the data first, as the background tracker would
+	if err := hasher.advance(150); err != nil {
+		t.Fatalf("advance failed: %v", err)
+	}
+
+	digest, err := hasher.Finish(300)
+	if err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+
+	sum := sha256.Sum256(content)
+	if digest != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected digest %x, got %s", sum, digest)
+	}
+
+	// Hashing beyond the end of the file fails
+	hasher = newPrefixHasher(sha256.New(), file)
+	if _, err := hasher.Finish(400); err == nil {
+		t.Error("Expected error when hashing past the end of the file")
+	}
+}
@@ -1,16 +1,35 @@
 package download
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
 	"sync"
 	"time"
 
 	"github.com/godownloader/internal/utils"
 )
 
+// ErrResourceChanged is returned when the remote file changes while it is
+// being downloaded, so its chunks would mix two versions of the file
+var ErrResourceChanged = errors.New("remote file changed during download")
+
+// ErrMirrorChanged is returned for a chunk whose mirror turned out to serve
+// a different version of the file. The mirror is disabled and the chunk can
+// be retried on another one.
+var ErrMirrorChanged = errors.New("mirror serves a different version of the file")
+
+// ErrTailMismatch is returned when the bytes a chunk already holds differ
+// from the server's, so they can't be resumed
+var ErrTailMismatch = errors.New("downloaded data does not match the server's")
+
+// tailOverlap is how many of the bytes a retried chunk already holds are
+// requested again, to check that they match before appending to them
+const tailOverlap = 4 * 1024
+
 // Worker represents a download worker
 type Worker struct {
 	ID        int
@@ -18,6 +37,20 @@ type Worker struct {
 	Results   chan<- *Result
 	WaitGroup *sync.WaitGroup
 	Client    *http.Client
+	// Mirrors, if set, picks the URL each chunk is downloaded from
+	Mirrors *MirrorSet
+	// Limiters throttle every read; all of them must admit the bytes
+	Limiters []*utils.RateLimiter
+	// RetryPolicy controls retries of each request; nil means the default
+	RetryPolicy *utils.RetryPolicy
+	// Request adds headers, credentials and cookies to every request
+	Request *utils.RequestOptions
+	// Timeouts abort a response body that stalls or arrives too slowly
+	Timeouts utils.Timeouts
+	// IfRange, if set, is sent as the If-Range validator of every request,
+	// and ETag, if set, must match the ETag of every response
+	IfRange string
+	ETag    string
 }
 
 // Result represents the result of a chunk download
@@ -33,24 +66,36 @@ func NewWorker(id int, jobQueue <-chan *Chunk, results chan<- *Result, wg *sync.
 		JobQueue:  jobQueue,
 		Results:   results,
 		WaitGroup: wg,
-		Client: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		Client:    utils.NewClient(nil),
 	}
 }
 
 // Start begins the worker's processing loop
 func (w *Worker) Start() {
+	w.StartContext(context.Background())
+}
+
+// StartContext begins the worker's processing loop. Once ctx is done, the
+// in-flight chunk is aborted and remaining jobs fail with the context's error.
+func (w *Worker) StartContext(ctx context.Context) {
 	go func() {
 		for chunk := range w.JobQueue {
 			result := &Result{
 				Chunk: chunk,
 			}
 
-			err := w.downloadChunk(chunk)
+			err := ctx.Err()
+			if err == nil && w.Mirrors != nil {
+				err = w.downloadChunkFromMirror(ctx, chunk)
+			} else if err == nil {
+				err = w.downloadChunk(ctx, chunk)
+			}
 			if err != nil {
 				result.Error = err
-				chunk.MarkFailed()
+				// Cancellation isn't the chunk's fault, so it doesn't count as a retry
+				if ctx.Err() == nil {
+					chunk.MarkFailed()
+				}
 			}
 
 			w.Results <- result
@@ -59,23 +104,71 @@ func (w *Worker) Start() {
 	}()
 }
 
+// downloadChunkFromMirror downloads a chunk from a mirror picked for it and
+// records the mirror's throughput or failure. A mirror whose file changed is
+// disabled; only once no mirror is left does the whole download stop.
+func (w *Worker) downloadChunkFromMirror(ctx context.Context, chunk *Chunk) error {
+	url := chunk.AssignMirror(w.Mirrors)
+	before := chunk.Record().Downloaded
+	start := time.Now()
+
+	err := w.downloadChunk(ctx, chunk)
+	switch {
+	case err == nil:
+		w.Mirrors.ReportSuccess(url, chunk.Record().Downloaded-before, time.Since(start))
+	case errors.Is(err, ErrResourceChanged):
+		if w.Mirrors.Disable(url) > 0 {
+			return fmt.Errorf("%w: %s: %v", ErrMirrorChanged, url, err)
+		}
+	case ctx.Err() == nil:
+		w.Mirrors.ReportFailure(url)
+	}
+
+	return err
+}
+
 // downloadChunk downloads a specific chunk
-func (w *Worker) downloadChunk(chunk *Chunk) error {
-	// Create the temp file
-	file, err := os.Create(chunk.TempFile)
+func (w *Worker) downloadChunk(ctx context.Context, chunk *Chunk) error {
+	// Nothing left to fetch for a chunk restored from a previous run
+	record := chunk.Record()
+	offset := record.Downloaded
+	if record.Start+offset > record.End {
+		return nil
+	}
+
+	// Open the chunk's destination, keeping any bytes from a previous run
+	file, err := chunk.OpenWriter()
 	if err != nil {
-		return fmt.Errorf("failed to create temp file: %w", err)
+		return err
 	}
 	defer file.Close()
 
-	// Create the request with range
-	req, err := utils.CreateHTTPRequest("GET", chunk.URL, chunk.Start, chunk.End)
+	// Create the request with range, skipping bytes already on disk. A
+	// retry also fetches the last few of them again to check them against.
+	// The chunk's end may move down while downloading if its tail is split off.
+	var overlap int64
+	if chunk.State().RetryCount > 0 {
+		overlap = min(offset, tailOverlap)
+	}
+	reqCtx, watchdog := w.Timeouts.Watch(ctx)
+	defer watchdog.Stop()
+	req, err := utils.CreateHTTPRequestContext(reqCtx, "GET", chunk.URL, record.Start+offset-overlap, record.End)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
+	w.Request.Apply(req)
+
+	// Only accept the range from the version of the file being downloaded
+	if w.IfRange != "" {
+		req.Header.Set("If-Range", w.IfRange)
+	}
 
 	// Send the request
-	resp, err := utils.DoRequestWithRetry(w.Client, req)
+	policy := w.RetryPolicy
+	if policy == nil {
+		policy = utils.DefaultRetryPolicy()
+	}
+	resp, err := policy.Do(w.Request.Client(w.Client), req)
 	if err != nil {
 		return fmt.Errorf("failed to send request: %w", err)
 	}
@@ -83,15 +176,54 @@ func (w *Worker) downloadChunk(chunk *Chunk) error {
 
 	// Verify status code
 	if resp.StatusCode != http.StatusPartialContent && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return &utils.StatusError{StatusCode: resp.StatusCode}
+	}
+
+	// A full response to a range request means If-Range didn't match, or
+	// that the server ignores ranges; either way the body isn't this chunk
+	if resp.StatusCode != http.StatusPartialContent {
+		if w.IfRange != "" {
+			return fmt.Errorf("chunk %d: %w", chunk.ID, ErrResourceChanged)
+		}
+		return fmt.Errorf("server ignored range request for chunk %d", chunk.ID)
+	}
+
+	if etag := resp.Header.Get("ETag"); w.ETag != "" && etag != "" && etag != w.ETag {
+		return fmt.Errorf("chunk %d: ETag %s differs from %s: %w", chunk.ID, etag, w.ETag, ErrResourceChanged)
 	}
 
 	// Create buffered writer for better performance
 	buffer := make([]byte, 32*1024) // 32KB buffer
 
+	// Abort the connection if the body stalls or is too slow, so the chunk
+	// is retried on a fresh one
+	watchdog.Start()
+
+	// Only append to the bytes on disk if they end like the server's do
+	if overlap > 0 {
+		if err := checkTail(chunk, resp.Body, overlap); err != nil {
+			if cause := watchdog.Err(); cause != nil {
+				return fmt.Errorf("chunk %d: %w", chunk.ID, cause)
+			}
+			return err
+		}
+		watchdog.Received(int(overlap))
+	}
+
 	for {
 		n, err := resp.Body.Read(buffer)
 		if n > 0 {
+			watchdog.Received(n)
+
+			// Never write past the chunk's current end
+			n = int(chunk.Reserve(int64(n)))
+
+			watchdog.Pause()
+			if err := waitLimiters(ctx, w.Limiters, n); err != nil {
+				return err
+			}
+			watchdog.Resume()
+
 			_, writeErr := file.Write(buffer[:n])
 			if writeErr != nil {
 				return fmt.Errorf("failed to write to file: %w", writeErr)
@@ -99,83 +231,70 @@ func (w *Worker) downloadChunk(chunk *Chunk) error {
 
 			// Update progress
 			chunk.UpdateProgress(int64(n))
+			if chunk.IsComplete() {
+				return nil
+			}
 		}
 
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if cause := watchdog.Err(); cause != nil {
+				return fmt.Errorf("chunk %d: %w", chunk.ID, cause)
+			}
 			return fmt.Errorf("failed to read response: %w", err)
 		}
 	}
 
+	if !chunk.IsComplete() {
+		return fmt.Errorf("chunk %d: %w", chunk.ID, io.ErrUnexpectedEOF)
+	}
+
 	return nil
 }
 
-// StartWorkerPool initializes and starts a pool of workers
-func StartWorkerPool(numWorkers int, chunks []*Chunk) ([]*Result, error) {
-	var wg sync.WaitGroup
-	jobQueue := make(chan *Chunk, len(chunks))
-	results := make(chan *Result, len(chunks))
-
-	// Create and start workers
-	for i := 0; i < numWorkers; i++ {
-		worker := NewWorker(i, jobQueue, results, &wg)
-		worker.Start()
+// checkTail reads the first n bytes of body and compares them with the last
+// n bytes the chunk holds. On a mismatch, the chunk's bytes are discarded so
+// its retry starts over.
+func checkTail(chunk *Chunk, body io.Reader, n int64) error {
+	received := make([]byte, n)
+	if _, err := io.ReadFull(body, received); err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
 	}
 
-	// Add jobs to the queue
-	for _, chunk := range chunks {
-		wg.Add(1)
-		jobQueue <- chunk
+	tail, err := chunk.ReadTail(n)
+	if err != nil {
+		return err
 	}
 
-	// Close the job queue
-	close(jobQueue)
-
-	// Wait for all downloads to complete
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-
-	// Collect results
-	var downloadResults []*Result
-	for result := range results {
-		downloadResults = append(downloadResults, result)
+	if !bytes.Equal(received, tail) {
+		chunk.Discard()
+		return fmt.Errorf("chunk %d: %w", chunk.ID, ErrTailMismatch)
 	}
-
-	return downloadResults, nil
+	return nil
 }
 
-// RetryFailedChunks attempts to download failed chunks
-func RetryFailedChunks(chunks []*Chunk, maxRetries int) error {
-	var failedChunks []*Chunk
-
-	// Find failed chunks that haven't exceeded retry limit
-	for _, chunk := range chunks {
-		if chunk.Failed && chunk.RetryCount < maxRetries {
-			chunk.ResetForRetry()
-			failedChunks = append(failedChunks, chunk)
+// waitLimiters blocks until every limiter admits n bytes
+func waitLimiters(ctx context.Context, limiters []*utils.RateLimiter, n int) error {
+	for _, limiter := range limiters {
+		if err := limiter.WaitN(ctx, n); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	if len(failedChunks) == 0 {
-		return nil
-	}
-
-	// Retry failed chunks
-	results, err := StartWorkerPool(len(failedChunks), failedChunks)
-	if err != nil {
-		return err
-	}
-
-	// Check for errors
-	for _, result := range results {
-		if result.Error != nil {
-			return fmt.Errorf("chunk %d failed: %w", result.Chunk.ID, result.Error)
-		}
-	}
+// StartWorkerPool initializes and starts a pool of workers
+func StartWorkerPool(numWorkers int, chunks []*Chunk) ([]*Result, error) {
+	return StartWorkerPoolContext(context.Background(), numWorkers, chunks)
+}
 
-	return nil
+// StartWorkerPoolContext is like StartWorkerPool but stops downloading when
+// ctx is done, returning the context's error
+func StartWorkerPoolContext(ctx context.Context, numWorkers int, chunks []*Chunk) ([]*Result, error) {
+	return NewWorkerPool(numWorkers, chunks).Run(ctx)
 }
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Checksum is an expected file digest
+type Checksum struct {
+	// Algorithm is one of sha256, sha512, sha1, md5 or blake2b
+	Algorithm string
+
+	// Digest is the lowercase hex digest. If empty, the digest is only
+	// computed, not verified.
+	Digest string
+}
+
+// ParseChecksum parses a checksum in the form "algorithm:hexdigest"
+func ParseChecksum(s string) (*Checksum, error) {
+	algorithm, digest, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid checksum %q, expected algorithm:digest", s)
+	}
+
+	checksum := &Checksum{
+		Algorithm: strings.ToLower(strings.TrimSpace(algorithm)),
+		Digest:    strings.ToLower(strings.TrimSpace(digest)),
+	}
+
+	h, err := checksum.NewHash()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := hex.DecodeString(checksum.Digest); err != nil || len(checksum.Digest) != 2*h.Size() {
+		return nil, fmt.Errorf("invalid %s digest %q", checksum.Algorithm, checksum.Digest)
+	}
+
+	return checksum, nil
+}
+
+// String returns the checksum in the form "algorithm:hexdigest"
+func (c *Checksum) String() string {
+	return c.Algorithm + ":" + c.Digest
+}
+
+// NewHash returns a new hash for the checksum's algorithm
+func (c *Checksum) NewHash() (hash.Hash, error) {
+	switch c.Algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "md5":
+		return md5.New(), nil
+	case "blake2b":
+		return blake2b.New512(nil)
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm: %q", c.Algorithm)
+	}
+}
+
+// Verify compares the computed digest with the expected one. It returns a
+// *ChecksumMismatchError if they differ.
+func (c *Checksum) Verify(actual string) error {
+	if c.Digest == "" || c.Digest == actual {
+		return nil
+	}
+
+	return &ChecksumMismatchError{
+		Algorithm: c.Algorithm,
+		Expected:  c.Digest,
+		Actual:    actual,
+	}
+}
+
+// ChecksumMismatchError reports a downloaded file whose digest differs from
+// the expected one
+type ChecksumMismatchError struct {
+	Algorithm string
+	Expected  string
+	Actual    string
+
+	// Path is where the rejected file was moved, if it was kept
+	Path string
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	msg := fmt.Sprintf("%s checksum mismatch: expected %s, got %s", e.Algorithm, e.Expected, e.Actual)
+	if e.Path != "" {
+		msg += fmt.Sprintf(" (file quarantined at %s)", e.Path)
+	}
+	return msg
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestParseChecksum(t *testing.T) {
+	digest := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+	checksum, err := ParseChecksum("SHA256:" + digest)
+	if err != nil {
+		t.Fatalf("ParseChecksum failed: %v", err)
+	}
+
+	if checksum.Algorithm != "sha256" || checksum.Digest != digest {
+		t.Errorf("Unexpected checksum: %+v", checksum)
+	}
+
+	if checksum.String() != "sha256:"+digest {
+		t.Errorf("Unexpected string form: %s", checksum.String())
+	}
+
+	// Invalid inputs
+	invalid := []string{
+		digest,                         // missing algorithm
+		"crc32:" + digest,              // unsupported algorithm
+		"sha256:abc",                   // wrong length
+		"sha256:" + digest[:62] + "zz", // not hex
+	}
+	for _, s := range invalid {
+		if _, err := ParseChecksum(s); err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+}
+
+func TestChecksumNewHash(t *testing.T) {
+	// Digests of "test" for every supported algorithm
+	expected := map[string]string{
+		"md5":    "098f6bcd4621d373cade4e832627b4f6",
+		"sha1":   "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3",
+		"sha256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		"sha512": "ee26b0dd4af7e749aa1a8ee3c10ae9923f618980772e473f8819a5d4940e0db27ac185f8a0e1d5f84f88bc887fd67b143732c304cc5fa9ad8e6f57f50028a8ff",
+		"blake2b": "a71079d42853dea26e453004338670a53814b78137ffbed07603a41d76a483aa" +
+			"9bc33b582f77d30a65e6f29a896c0411f38312e1d66e0bf16386c86a89bea572",
+	}
+
+	for algorithm, digest := range expected {
+		checksum := &Checksum{Algorithm: algorithm}
+		h, err := checksum.NewHash()
+		if err != nil {
+			t.Fatalf("NewHash(%s) failed: %v", algorithm, err)
+		}
+
+		h.Write([]byte("test"))
+		if got := hex.EncodeToString(h.Sum(nil)); got != digest {
+			t.Errorf("Expected %s digest %s, got %s", algorithm, digest, got)
+		}
+	}
+}
+
+func TestChecksumVerify(t *testing.T) {
+	checksum := &Checksum{Algorithm: "md5", Digest: "098f6bcd4621d373cade4e832627b4f6"}
+
+	if err := checksum.Verify("098f6bcd4621d373cade4e832627b4f6"); err != nil {
+		t.Errorf("Expected matching digest to verify, got %v", err)
+	}
+
+	err := checksum.Verify("00000000000000000000000000000000")
+	var mismatch *ChecksumMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("Expected ChecksumMismatchError, got %v", err)
+	}
+
+	if mismatch.Expected != checksum.Digest {
+		t.Errorf("Expected digest %s in error, got %s", checksum.Digest, mismatch.Expected)
+	}
+
+	// Without an expected digest nothing is verified
+	computeOnly := &Checksum{Algorithm: "md5"}
+	if err := computeOnly.Verify("anything"); err != nil {
+		t.Errorf("Expected no error without a digest, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"net/http"
+)
+
+// maxIdleConnsPerHost keeps a connection per thread open between requests,
+// well above the 2 of http.DefaultTransport
+const maxIdleConnsPerHost = 64
+
+// defaultTransport is shared by all default clients, so the probe and the
+// chunks of every download reuse the same connections
+var defaultTransport = newTransport(Timeouts{})
+
+// DefaultTransport returns the transport shared by all default clients. It
+// keeps up to 64 idle connections per host and doesn't limit the number of
+// connections per host, so every thread of a download gets its own. It uses
+// the default Timeouts.
+func DefaultTransport() *http.Transport {
+	return defaultTransport
+}
+
+// newTransport returns a transport like http.DefaultTransport, tuned to
+// keep the connections of many threads alive and bounded by timeouts
+func newTransport(timeouts Timeouts) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	timeouts.apply(transport)
+	transport.MaxIdleConns = 0
+	transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	transport.MaxConnsPerHost = 0
+	return transport
+}
+
+// NewClient returns a client that sends requests with transport, or with
+// the shared DefaultTransport if it is nil. It sets no overall timeout, so
+// large bodies may take as long as they keep arriving.
+func NewClient(transport http.RoundTripper) *http.Client {
+	if transport == nil {
+		transport = defaultTransport
+	}
+	return &http.Client{
+		Transport: transport,
+	}
+}
+
+// ClientOptions configures the HTTP client of a download
+type ClientOptions struct {
+	// Proxy is the URL of the proxy every request goes through, except for
+	// hosts matched by NoProxy; see ProxyFunc. If empty, the proxy is taken
+	// from the environment.
+	Proxy   string
+	NoProxy string
+
+	// TLS, if set, configures certificate verification and client
+	// certificates
+	TLS *TLSOptions
+
+	// Timeouts bound connecting, the TLS handshake and waiting for the
+	// response headers
+	Timeouts Timeouts
+}
+
+// NewHTTPClient returns a client configured by opts. Without a proxy, TLS
+// options or custom timeouts, it uses the shared DefaultTransport;
+// otherwise it gets a transport of its own.
+func NewHTTPClient(opts ClientOptions) (*http.Client, error) {
+	if opts.Proxy == "" && opts.NoProxy == "" && opts.TLS == nil && opts.Timeouts.defaultTransport() {
+		return NewClient(nil), nil
+	}
+
+	transport := newTransport(opts.Timeouts)
+
+	if opts.Proxy != "" || opts.NoProxy != "" {
+		proxy, err := ProxyFunc(opts.Proxy, opts.NoProxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = proxy
+	}
+
+	if opts.TLS != nil {
+		config, err := opts.TLS.Config()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = config
+	}
+
+	return NewClient(transport), nil
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	client, err := NewHTTPClient(ClientOptions{})
+	if err != nil {
+		t.Fatalf("NewHTTPClient failed: %v", err)
+	}
+	if client.Transport != DefaultTransport() {
+		t.Error("Expected the default client to use the shared transport")
+	}
+	if client.Timeout != 0 {
+		t.Errorf("Expected no overall timeout, got %v", client.Timeout)
+	}
+
+	client, err = NewHTTPClient(ClientOptions{Proxy: "http://proxy.example.com:3128"})
+	if err != nil {
+		t.Fatalf("NewHTTPClient failed: %v", err)
+	}
+	if client.Transport == DefaultTransport() {
+		t.Error("Expected a proxied client to get a transport of its own")
+	}
+
+	client, err = NewHTTPClient(ClientOptions{Timeouts: Timeouts{Dial: 5 * time.Second}})
+	if err != nil {
+		t.Fatalf("NewHTTPClient failed: %v", err)
+	}
+	if transport, ok := client.Transport.(*http.Transport); !ok || transport == DefaultTransport() {
+		t.Error("Expected a client with custom timeouts to get a transport of its own")
+	}
+
+	if _, err := NewHTTPClient(ClientOptions{Proxy: "ftp://proxy.example.com"}); err == nil {
+		t.Error("Expected an error for an unsupported proxy scheme")
+	}
+}
+
+func TestDefaultTransport(t *testing.T) {
+	transport := DefaultTransport()
+	if transport.MaxIdleConnsPerHost < 64 {
+		t.Errorf("Expected at least 64 idle connections per host, got %d", transport.MaxIdleConnsPerHost)
+	}
+	if transport.MaxConnsPerHost != 0 {
+		t.Errorf("Expected no limit of connections per host, got %d", transport.MaxConnsPerHost)
+	}
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseCookies(t *testing.T) {
+	input := "# Netscape HTTP Cookie File\n" +
+		"\n" +
+		".example.com\tTRUE\t/\tFALSE\t0\tshared\t1\n" +
+		"#HttpOnly_files.example.com\tFALSE\t/downloads\tTRUE\t4102444800\tsession\tabc\n" +
+		"example.com\tFALSE\t/\tFALSE\t946684800\texpired\told\n"
+
+	jar, err := ParseCookies(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseCookies failed: %v", err)
+	}
+
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{"https://files.example.com/downloads/a.zip", []string{"session", "shared"}},
+		{"http://files.example.com/downloads/a.zip", []string{"shared"}},
+		{"https://files.example.com/other", []string{"shared"}},
+		{"https://example.com/", []string{"shared"}},
+		{"https://example.org/", nil},
+	}
+
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		var names []string
+		for _, cookie := range jar.Cookies(u) {
+			names = append(names, cookie.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("Cookies(%s) = %v, want %v", tt.url, names, tt.want)
+		}
+	}
+
+	if _, err := ParseCookies(strings.NewReader("example.com\tFALSE\t/\n")); err == nil {
+		t.Error("Expected an error for a line with missing fields")
+	}
+}
@@ -38,14 +38,26 @@ func CreateFile(path string) (*os.File, error) {
 	return file, nil
 }
 
-// MergeFiles merges multiple files into a single output file
+// MergeFiles merges multiple files into a single output file and flushes it
+// to disk
 func MergeFiles(outputPath string, inputPaths []string) error {
+	return MergeFilesTee(outputPath, inputPaths, nil)
+}
+
+// MergeFilesTee is like MergeFiles but also writes the merged data to tee,
+// if not nil, such as a hash computed in the same pass
+func MergeFilesTee(outputPath string, inputPaths []string, tee io.Writer) error {
 	outFile, err := CreateFile(outputPath)
 	if err != nil {
 		return err
 	}
 	defer outFile.Close()
 
+	var out io.Writer = outFile
+	if tee != nil {
+		out = io.MultiWriter(outFile, tee)
+	}
+
 	buffer := make([]byte, 32*1024) // 32KB buffer for efficient copying
 
 	for _, inputPath := range inputPaths {
@@ -54,7 +66,7 @@ func MergeFiles(outputPath string, inputPaths []string) error {
 			return fmt.Errorf("failed to open file %s: %w", inputPath, err)
 		}
 
-		_, err = io.CopyBuffer(outFile, inFile, buffer)
+		_, err = io.CopyBuffer(out, inFile, buffer)
 		inFile.Close() // Close each file after copying
 
 		if err != nil {
@@ -62,6 +74,10 @@ func MergeFiles(outputPath string, inputPaths []string) error {
 		}
 	}
 
+	if err := outFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", outputPath, err)
+	}
+
 	return nil
 }
 
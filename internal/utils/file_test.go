@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -103,3 +105,37 @@ func TestCleanupTempDir(t *testing.T) {
 		t.Errorf("Unexpected error checking directory: %v", err)
 	}
 }
+
+func TestMergeFilesTee(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "file_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var inputs []string
+	for i, part := range []string{"first ", "second ", "third"} {
+		path := filepath.Join(tempDir, fmt.Sprintf("part%d", i))
+		if err := os.WriteFile(path, []byte(part), 0644); err != nil {
+			t.Fatalf("Failed to write part: %v", err)
+		}
+		inputs = append(inputs, path)
+	}
+
+	h := sha256.New()
+	outputPath := filepath.Join(tempDir, "merged")
+	if err := MergeFilesTee(outputPath, inputs, h); err != nil {
+		t.Fatalf("MergeFilesTee failed: %v", err)
+	}
+
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatalf("Failed to read merged file: %v", err)
+	}
+	if string(data) != "first second third" {
+		t.Errorf("Unexpected merged content %q", data)
+	}
+	if sum := sha256.Sum256(data); string(h.Sum(nil)) != string(sum[:]) {
+		t.Error("Expected the tee to receive the merged data")
+	}
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// DefaultFilename is used when nothing better names a download
+const DefaultFilename = "download"
+
+// ResolveFilename picks the local name for a download. It prefers the name
+// suggested by Content-Disposition, then the last segment of the URL path,
+// then a name derived from the content type. The result is always a single
+// path element, safe to join to a directory.
+func ResolveFilename(suggested, rawURL, contentType string) string {
+	if name := SanitizeFilename(suggested); name != "" {
+		return name
+	}
+
+	// url.Parse percent-decodes the path and drops the query
+	if u, err := url.Parse(rawURL); err == nil {
+		if name := SanitizeFilename(path.Base(u.Path)); name != "" {
+			return name
+		}
+	}
+
+	return filenameForType(contentType)
+}
+
+// SanitizeFilename reduces a remote name to a single safe path element. It
+// drops any directories, control characters and characters not allowed on
+// common filesystems. It returns "" if nothing usable is left.
+func SanitizeFilename(name string) string {
+	// Treat both separators as directories, whatever the local OS
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = path.Base(name)
+
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case r < 0x20 || r == 0x7f:
+			return -1
+		case strings.ContainsRune(`<>:"|?*`, r):
+			return '_'
+		}
+		return r
+	}, name)
+
+	// Leading dots would hide the file or climb out of the directory, and
+	// path.Base returns "/" for a bare root
+	name = strings.TrimLeft(strings.TrimSpace(name), "./")
+	name = strings.TrimRight(name, ". ")
+	return name
+}
+
+// filenameForType names a download after its content type, such as
+// index.html for a web page
+func filenameForType(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return DefaultFilename
+	}
+
+	if mediaType == "text/html" {
+		return "index.html"
+	}
+
+	extensions, err := mime.ExtensionsByType(mediaType)
+	if err != nil || len(extensions) == 0 {
+		return DefaultFilename
+	}
+	return DefaultFilename + extensions[0]
+}
@@ -0,0 +1,51 @@
+package utils
+
+import "testing"
+
+func TestResolveFilename(t *testing.T) {
+	tests := []struct {
+		suggested   string
+		url         string
+		contentType string
+		expected    string
+	}{
+		{"", "https://example.com/files/archive.zip", "", "archive.zip"},
+		{"", "https://example.com/download?id=42&token=abc", "", "download"},
+		{"", "https://example.com/files/my%20report.pdf?sig=x", "", "my report.pdf"},
+		{"report.pdf", "https://example.com/download?id=42", "", "report.pdf"},
+		{"", "https://example.com/", "text/html; charset=utf-8", "index.html"},
+		{"", "https://example.com", "", "download"},
+		{"", "https://example.com/", "application/json", "download.json"},
+		{"../../etc/passwd", "https://example.com/a.zip", "", "passwd"},
+		{"..", "https://example.com/a.zip", "", "a.zip"},
+		{"", "https://example.com/%2e%2e", "", "download"},
+	}
+
+	for _, test := range tests {
+		name := ResolveFilename(test.suggested, test.url, test.contentType)
+		if name != test.expected {
+			t.Errorf("ResolveFilename(%q, %q, %q) = %q, expected %q",
+				test.suggested, test.url, test.contentType, name, test.expected)
+		}
+	}
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := map[string]string{
+		"file.zip":            "file.zip",
+		`C:\Windows\evil.exe`: "evil.exe",
+		"/abs/path/name.txt":  "name.txt",
+		"a:b*c?.txt":          "a_b_c_.txt",
+		"line\nbreak.txt":     "linebreak.txt",
+		".hidden":             "hidden",
+		"...":                 "",
+		"  spaced name.txt  ": "spaced name.txt",
+		"trailing.":           "trailing",
+	}
+
+	for input, expected := range tests {
+		if name := SanitizeFilename(input); name != expected {
+			t.Errorf("SanitizeFilename(%q) = %q, expected %q", input, name, expected)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"time"
@@ -8,75 +9,29 @@ import (
 
 const (
 	maxRetries    = 3
-	retryInterval = 2 * time.Second
+	retryInterval = time.Second
 	userAgent     = "Go-Downloader/1.0"
 )
 
-// GetContentLength sends a HEAD request to get file size
-func GetContentLength(url string) (int64, error) {
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
-
-	req, err := http.NewRequest("HEAD", url, nil)
-	if err != nil {
-		return 0, err
-	}
-
-	req.Header.Set("User-Agent", userAgent)
-
-	var resp *http.Response
-	var retryCount int
-
-	for retryCount < maxRetries {
-		resp, err = client.Do(req)
-		if err == nil {
-			defer resp.Body.Close()
-			if resp.StatusCode == http.StatusOK {
-				return resp.ContentLength, nil
-			}
-			err = fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-		}
-
-		retryCount++
-		if retryCount < maxRetries {
-			time.Sleep(retryInterval)
-		}
-	}
-
-	return 0, err
+// StatusError reports an HTTP response with an unexpected status code
+type StatusError struct {
+	StatusCode int
 }
 
-// CheckRangeSupport checks if the server supports range requests
-func CheckRangeSupport(url string) (bool, error) {
-	client := &http.Client{
-		Timeout: 30 * time.Second,
-	}
-
-	req, err := http.NewRequest("HEAD", url, nil)
-	if err != nil {
-		return false, err
-	}
-
-	req.Header.Set("User-Agent", userAgent)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return false, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
-
-	acceptRanges := resp.Header.Get("Accept-Ranges")
-	return acceptRanges == "bytes", nil
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
 }
 
-// CreateHTTPRequest creates an HTTP request with appropriate headers
+// CreateHTTPRequest creates an HTTP request with appropriate headers. A
+// negative rangeStart requests the whole file, and a negative rangeEnd
+// requests everything from rangeStart on.
 func CreateHTTPRequest(method, url string, rangeStart, rangeEnd int64) (*http.Request, error) {
-	req, err := http.NewRequest(method, url, nil)
+	return CreateHTTPRequestContext(context.Background(), method, url, rangeStart, rangeEnd)
+}
+
+// CreateHTTPRequestContext is like CreateHTTPRequest but the request is bound to ctx
+func CreateHTTPRequestContext(ctx context.Context, method, url string, rangeStart, rangeEnd int64) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -86,32 +41,30 @@ func CreateHTTPRequest(method, url string, rangeStart, rangeEnd int64) (*http.Re
 	if rangeStart >= 0 && rangeEnd >= 0 {
 		rangeHeader := fmt.Sprintf("bytes=%d-%d", rangeStart, rangeEnd)
 		req.Header.Set("Range", rangeHeader)
+	} else if rangeStart >= 0 {
+		// Open-ended range up to the end of the file
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", rangeStart))
 	}
 
 	return req, nil
 }
 
-// DoRequestWithRetry performs an HTTP request with retry logic
+// DoRequestWithRetry performs an HTTP request with the default retry
+// policy. Retries stop early when the request's context is done.
 func DoRequestWithRetry(client *http.Client, req *http.Request) (*http.Response, error) {
-	var resp *http.Response
-	var err error
-	var retryCount int
-
-	for retryCount < maxRetries {
-		resp, err = client.Do(req)
-		if err == nil {
-			if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
-				return resp, nil
-			}
-			resp.Body.Close()
-			err = fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-		}
+	return DefaultRetryPolicy().Do(client, req)
+}
 
-		retryCount++
-		if retryCount < maxRetries {
-			time.Sleep(retryInterval)
-		}
+// Sleep pauses for the given duration, returning early with the context's
+// error if ctx is done first
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-
-	return nil, err
 }
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -35,78 +37,6 @@ func TestCreateHTTPRequest(t *testing.T) {
 	}
 }
 
-func TestGetContentLength(t *testing.T) {
-	// Create test server
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Length", "1000")
-		w.WriteHeader(http.StatusOK)
-	}))
-	defer server.Close()
-
-	// Test success case
-	length, err := GetContentLength(server.URL)
-	if err != nil {
-		t.Fatalf("GetContentLength failed: %v", err)
-	}
-
-	if length != 1000 {
-		t.Errorf("Expected content length to be 1000, got %d", length)
-	}
-
-	// Test error case - invalid URL
-	_, err = GetContentLength("http://invalid-url-that-does-not-exist.example")
-	if err == nil {
-		t.Error("Expected GetContentLength to fail with invalid URL")
-	}
-
-	// Test error case - server error
-	errorServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusInternalServerError)
-	}))
-	defer errorServer.Close()
-
-	_, err = GetContentLength(errorServer.URL)
-	if err == nil {
-		t.Error("Expected GetContentLength to fail with server error")
-	}
-}
-
-func TestCheckRangeSupport(t *testing.T) {
-	// Server that supports range requests
-	rangeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Accept-Ranges", "bytes")
-		w.WriteHeader(http.StatusOK)
-	}))
-	defer rangeServer.Close()
-
-	// Test server that supports ranges
-	supported, err := CheckRangeSupport(rangeServer.URL)
-	if err != nil {
-		t.Fatalf("CheckRangeSupport failed: %v", err)
-	}
-
-	if !supported {
-		t.Error("Expected range support to be true")
-	}
-
-	// Server that doesn't support range requests
-	noRangeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// No Accept-Ranges header
-		w.WriteHeader(http.StatusOK)
-	}))
-	defer noRangeServer.Close()
-
-	// Test server that doesn't support ranges
-	supported, err = CheckRangeSupport(noRangeServer.URL)
-	if err != nil {
-		t.Fatalf("CheckRangeSupport failed: %v", err)
-	}
-
-	if supported {
-		t.Error("Expected range support to be false")
-	}
-}
-
 func TestDoRequestWithRetry(t *testing.T) {
 	client := &http.Client{
 		Timeout: 1 * time.Second,
@@ -170,3 +100,34 @@ func TestDoRequestWithRetry(t *testing.T) {
 		t.Errorf("Expected at least 3 attempts, got %d", attemptCount)
 	}
 }
+
+func TestDoRequestWithRetryCanceled(t *testing.T) {
+	client := &http.Client{
+		Timeout: 1 * time.Second,
+	}
+
+	// Server that always fails, so the request would normally be retried
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	req, err := CreateHTTPRequestContext(ctx, "GET", server.URL, -1, -1)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	start := time.Now()
+	_, err = DoRequestWithRetry(client, req)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// The retry sleep must not outlive the context
+	if elapsed := time.Since(start); elapsed >= retryInterval {
+		t.Errorf("Expected retry loop to stop early, took %v", elapsed)
+	}
+}
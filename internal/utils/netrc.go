@@ -0,0 +1,135 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// netrcEntry holds the credentials of one machine in a .netrc file
+type netrcEntry struct {
+	machine  string
+	login    string
+	password string
+}
+
+// Netrc holds the credentials of a .netrc file
+type Netrc struct {
+	entries []netrcEntry
+}
+
+// DefaultNetrcPath returns the path in $NETRC, or ~/.netrc
+func DefaultNetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// LoadNetrc reads a .netrc file. A missing file holds no credentials.
+func LoadNetrc(path string) (*Netrc, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return &Netrc{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	netrc, err := ParseNetrc(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return netrc, nil
+}
+
+// ParseNetrc parses the machine, default, login and password tokens of a
+// .netrc file. Macro definitions are skipped.
+func ParseNetrc(r io.Reader) (*Netrc, error) {
+	netrc := &Netrc{}
+	var entry *netrcEntry
+	var inMacro bool
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// A macro runs until the next blank line
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			token := fields[i]
+			if strings.HasPrefix(token, "#") {
+				break
+			}
+
+			switch token {
+			case "default":
+				netrc.entries = append(netrc.entries, netrcEntry{})
+				entry = &netrc.entries[len(netrc.entries)-1]
+				continue
+			case "macdef":
+				inMacro = true
+				i = len(fields)
+				continue
+			}
+
+			if i+1 >= len(fields) {
+				return nil, fmt.Errorf("missing value for %q", token)
+			}
+			i++
+			value := fields[i]
+
+			switch token {
+			case "machine":
+				netrc.entries = append(netrc.entries, netrcEntry{machine: value})
+				entry = &netrc.entries[len(netrc.entries)-1]
+			case "login", "password", "account":
+				if entry == nil {
+					return nil, fmt.Errorf("%q outside of a machine entry", token)
+				}
+				if token == "login" {
+					entry.login = value
+				} else if token == "password" {
+					entry.password = value
+				}
+			default:
+				return nil, fmt.Errorf("unknown token %q", token)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return netrc, nil
+}
+
+// Lookup returns the credentials for host, falling back to the default
+// entry
+func (n *Netrc) Lookup(host string) (login, password string, ok bool) {
+	for _, entry := range n.entries {
+		if entry.machine != "" && strings.EqualFold(entry.machine, host) {
+			return entry.login, entry.password, true
+		}
+	}
+	for _, entry := range n.entries {
+		if entry.machine == "" {
+			return entry.login, entry.password, true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseNetrc(t *testing.T) {
+	input := `# credentials
+machine example.com
+	login alice
+	password secret
+
+macdef init
+cd /pub
+bin
+
+machine files.example.org login bob password hunter2 account ops
+default login anonymous password guest
+`
+
+	netrc, err := ParseNetrc(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseNetrc failed: %v", err)
+	}
+
+	tests := []struct {
+		host         string
+		wantLogin    string
+		wantPassword string
+	}{
+		{"example.com", "alice", "secret"},
+		{"FILES.example.org", "bob", "hunter2"},
+		{"unknown.net", "anonymous", "guest"},
+	}
+
+	for _, tt := range tests {
+		login, password, ok := netrc.Lookup(tt.host)
+		if !ok || login != tt.wantLogin || password != tt.wantPassword {
+			t.Errorf("Lookup(%q) = %q, %q, %v; want %q, %q", tt.host, login, password, ok, tt.wantLogin, tt.wantPassword)
+		}
+	}
+
+	for _, invalid := range []string{"machine", "login alice", "machine a.com user alice"} {
+		if _, err := ParseNetrc(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestLoadNetrc(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "netrc_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A missing file holds no credentials
+	netrc, err := LoadNetrc(filepath.Join(tempDir, ".netrc"))
+	if err != nil {
+		t.Fatalf("LoadNetrc failed: %v", err)
+	}
+	if _, _, ok := netrc.Lookup("example.com"); ok {
+		t.Error("Expected no credentials from a missing file")
+	}
+
+	path := filepath.Join(tempDir, "netrc")
+	if err := os.WriteFile(path, []byte("machine example.com login alice password secret\n"), 0600); err != nil {
+		t.Fatalf("Failed to write netrc: %v", err)
+	}
+	t.Setenv("NETRC", path)
+
+	netrc, err = LoadNetrc(DefaultNetrcPath())
+	if err != nil {
+		t.Fatalf("LoadNetrc failed: %v", err)
+	}
+	if login, _, ok := netrc.Lookup("example.com"); !ok || login != "alice" {
+		t.Errorf("Expected alice from $NETRC, got %q", login)
+	}
+}
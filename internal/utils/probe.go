@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ProbeResult describes a remote file
+type ProbeResult struct {
+	// URL is where the file was found after following redirects, and
+	// Redirects lists the URLs redirected to, in order
+	URL       string
+	Redirects []string
+
+	// ContentLength is the file size, or -1 if the server doesn't report it
+	ContentLength  int64
+	SupportsRanges bool
+
+	// ETag and Last-Modified identify the version of the file
+	ETag         string
+	LastModified string
+
+	// Filename is the name suggested by Content-Disposition, if any
+	Filename    string
+	ContentType string
+}
+
+// headUnsupported lists the HEAD statuses that only mean the server won't
+// answer HEAD, such as URLs presigned for GET. Other statuses, in
+// particular the retryable ones, are returned without trying GET.
+var headUnsupported = []int{
+	http.StatusForbidden,
+	http.StatusMethodNotAllowed,
+	http.StatusNotImplemented,
+}
+
+// Probe finds out the size, range support, validators, suggested filename
+// and content type of a remote file with a HEAD request. If the server
+// doesn't support HEAD or doesn't report a size, it falls back to a GET of
+// the first byte and reads the size from Content-Range. Redirects are followed up to
+// opts.MaxRedirects, applying opts, which may be nil, to every request.
+func Probe(ctx context.Context, client *http.Client, url string, policy *RetryPolicy, opts *RequestOptions) (*ProbeResult, error) {
+	head, err := probeHead(ctx, client, url, policy, opts)
+
+	var status *StatusError
+	if err != nil && (!errors.As(err, &status) || !slices.Contains(headUnsupported, status.StatusCode)) {
+		return nil, err
+	}
+	if err == nil && head.ContentLength >= 0 {
+		return head, nil
+	}
+
+	// Report the GET's error; a HEAD-only failure isn't the real problem
+	result, err := probeGet(ctx, client, url, policy, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep range support advertised by a HEAD that only lacked the size
+	if head != nil {
+		result.SupportsRanges = result.SupportsRanges || head.SupportsRanges
+	}
+	return result, nil
+}
+
+// probeHead probes the file with a HEAD request
+func probeHead(ctx context.Context, client *http.Client, url string, policy *RetryPolicy, opts *RequestOptions) (*ProbeResult, error) {
+	resp, chain, err := followRedirects(ctx, client, policy, opts, "HEAD", url, -1, -1)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	result := newProbeResult(resp, chain)
+	result.ContentLength = resp.ContentLength
+	result.SupportsRanges = resp.Header.Get("Accept-Ranges") == "bytes"
+	return result, nil
+}
+
+// probeGet probes the file with a GET of its first byte. The body is not
+// read; at most one byte of it is sent.
+func probeGet(ctx context.Context, client *http.Client, url string, policy *RetryPolicy, opts *RequestOptions) (*ProbeResult, error) {
+	resp, chain, err := followRedirects(ctx, client, policy, opts, "GET", url, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := newProbeResult(resp, chain)
+	if resp.StatusCode != http.StatusPartialContent {
+		// The server ignored the range and is sending the whole file
+		result.ContentLength = resp.ContentLength
+		return result, nil
+	}
+
+	size, err := ParseContentRangeSize(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, err
+	}
+	result.ContentLength = size
+	result.SupportsRanges = true
+	return result, nil
+}
+
+// newProbeResult reads the headers common to both probes
+func newProbeResult(resp *http.Response, chain []string) *ProbeResult {
+	result := &ProbeResult{
+		URL:          resp.Request.URL.String(),
+		Redirects:    chain,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		ContentType:  resp.Header.Get("Content-Type"),
+	}
+
+	// mime decodes both filename and the RFC 5987 filename* form
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		result.Filename = params["filename"]
+	}
+
+	return result
+}
+
+// ParseContentRangeSize returns the complete length from a Content-Range
+// header such as "bytes 0-0/1234", or -1 if it is given as "*"
+func ParseContentRangeSize(contentRange string) (int64, error) {
+	unit, rest, ok := strings.Cut(contentRange, " ")
+	_, size, hasSize := strings.Cut(rest, "/")
+	if !ok || !hasSize || unit != "bytes" {
+		return 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+
+	if size == "*" {
+		return -1, nil
+	}
+
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid Content-Range %q", contentRange)
+	}
+	return n, nil
+}
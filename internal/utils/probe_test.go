@@ -0,0 +1,152 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	client := &http.Client{Timeout: time.Second}
+	content := "0123456789"
+
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("ETag", `"abc"`)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename*=UTF-8''r%C3%A9sum%C3%A9.zip`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer server.Close()
+
+	// A usable HEAD answers everything in one request
+	probe, err := Probe(context.Background(), client, server.URL, DefaultRetryPolicy(), nil)
+	if err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+
+	if requests.Load() != 1 {
+		t.Errorf("Expected a single request, got %d", requests.Load())
+	}
+	expected := ProbeResult{
+		URL:            server.URL,
+		ContentLength:  int64(len(content)),
+		SupportsRanges: true,
+		ETag:           `"abc"`,
+		LastModified:   "Mon, 02 Jan 2006 15:04:05 GMT",
+		Filename:       "résumé.zip",
+		ContentType:    "application/zip",
+	}
+	if !reflect.DeepEqual(*probe, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, *probe)
+	}
+
+	// A server that doesn't advertise ranges
+	noRange := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+	}))
+	defer noRange.Close()
+
+	probe, err = Probe(context.Background(), client, noRange.URL, DefaultRetryPolicy(), nil)
+	if err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	if probe.ContentLength != 1000 || probe.SupportsRanges {
+		t.Errorf("Expected 1000 bytes without ranges, got %+v", probe)
+	}
+}
+
+func TestProbeGetFallback(t *testing.T) {
+	client := &http.Client{Timeout: time.Second}
+
+	// Servers that reject HEAD, like presigned URLs, or omit the size
+	handlers := map[string]http.HandlerFunc{
+		"method not allowed": func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+		},
+		"no content length": func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Accept-Ranges", "bytes")
+			if r.Method == "HEAD" {
+				w.(http.Flusher).Flush()
+				return
+			}
+			http.ServeContent(w, r, "", time.Time{}, strings.NewReader("0123456789"))
+		},
+	}
+
+	for name, handler := range handlers {
+		server := httptest.NewServer(handler)
+
+		probe, err := Probe(context.Background(), client, server.URL, DefaultRetryPolicy(), nil)
+		if err != nil {
+			t.Errorf("%s: Probe failed: %v", name, err)
+		} else if probe.ContentLength != 10 || !probe.SupportsRanges {
+			t.Errorf("%s: expected 10 bytes with ranges, got %+v", name, probe)
+		}
+
+		server.Close()
+	}
+
+	// A missing file fails with its status, without a GET
+	missing := httptest.NewServer(http.NotFoundHandler())
+	defer missing.Close()
+
+	_, err := Probe(context.Background(), client, missing.URL, DefaultRetryPolicy(), nil)
+	var status *StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 StatusError, got %v", err)
+	}
+
+	// A rate-limited HEAD isn't repeated as a GET once its attempts run out
+	var gets atomic.Int32
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			gets.Add(1)
+		}
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer limited.Close()
+
+	policy := DefaultRetryPolicy()
+	policy.BaseDelay = time.Millisecond
+	_, err = Probe(context.Background(), client, limited.URL, policy, nil)
+	if !errors.As(err, &status) || status.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected a 429 StatusError, got %v", err)
+	}
+	if gets.Load() != 0 {
+		t.Errorf("Expected no GET after a rate-limited HEAD, got %d", gets.Load())
+	}
+}
+
+func TestParseContentRangeSize(t *testing.T) {
+	valid := map[string]int64{
+		"bytes 0-0/1234": 1234,
+		"bytes 0-99/100": 100,
+		"bytes 0-0/*":    -1,
+		"bytes */0":      0,
+	}
+	for header, expected := range valid {
+		size, err := ParseContentRangeSize(header)
+		if err != nil || size != expected {
+			t.Errorf("ParseContentRangeSize(%q) = %d, %v; expected %d", header, size, err, expected)
+		}
+	}
+
+	for _, header := range []string{"", "bytes 0-0", "items 0-0/10", "bytes 0-0/x"} {
+		if _, err := ParseContentRangeSize(header); err == nil {
+			t.Errorf("Expected error for %q", header)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// proxySchemes are the proxy URL schemes understood by http.Transport
+var proxySchemes = []string{"http", "https", "socks5", "socks5h"}
+
+// ProxyFunc returns a proxy selector for http.Transport that sends every
+// request through proxyURL, except requests for hosts matched by noProxy.
+// proxyURL may carry credentials as user:password. noProxy is a
+// comma-separated list in the style of NO_PROXY; if empty, NO_PROXY from
+// the environment is used. If proxyURL is empty, the proxy is taken from
+// the environment as by http.ProxyFromEnvironment, and noProxy excludes
+// hosts in addition to NO_PROXY.
+func ProxyFunc(proxyURL, noProxy string) (func(*http.Request) (*url.URL, error), error) {
+	if proxyURL == "" {
+		return func(req *http.Request) (*url.URL, error) {
+			if MatchNoProxy(noProxy, req.URL.Host) {
+				return nil, nil
+			}
+			return http.ProxyFromEnvironment(req)
+		}, nil
+	}
+
+	proxy, err := ParseProxyURL(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if noProxy == "" {
+		noProxy = os.Getenv("NO_PROXY")
+		if noProxy == "" {
+			noProxy = os.Getenv("no_proxy")
+		}
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if MatchNoProxy(noProxy, req.URL.Host) {
+			return nil, nil
+		}
+		return proxy, nil
+	}, nil
+}
+
+// ParseProxyURL parses an http://, https://, socks5:// or socks5h:// proxy
+// URL
+func ParseProxyURL(proxyURL string) (*url.URL, error) {
+	proxy, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+
+	if !slices.Contains(proxySchemes, proxy.Scheme) || proxy.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q, expected http://, https:// or socks5://host:port", proxyURL)
+	}
+
+	return proxy, nil
+}
+
+// MatchNoProxy reports whether host, with an optional port, is excluded
+// from proxying by noProxy. Each comma- or space-separated entry is "*",
+// an IP address, a CIDR range or a domain name, which also matches its
+// subdomains; entries may name a port.
+func MatchNoProxy(noProxy, host string) bool {
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname, port = host, ""
+	}
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	ip := net.ParseIP(hostname)
+
+	for _, entry := range strings.FieldsFunc(strings.ToLower(noProxy), func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		if entry == "*" {
+			return true
+		}
+
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		// An entry's port must match as well
+		entryHost, entryPort, err := net.SplitHostPort(entry)
+		if err != nil {
+			entryHost, entryPort = entry, ""
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		entryHost = strings.Trim(entryHost, "[]")
+
+		if entryIP := net.ParseIP(entryHost); entryIP != nil {
+			if ip != nil && entryIP.Equal(ip) {
+				return true
+			}
+			continue
+		}
+
+		domain := strings.TrimPrefix(strings.TrimPrefix(entryHost, "*"), ".")
+		if hostname == domain || strings.HasSuffix(hostname, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,251 @@
+package utils
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMatchNoProxy(t *testing.T) {
+	noProxy := "example.com, .internal.net,10.0.0.0/8 192.168.1.5,registry.local:5000"
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"example.com", true},
+		{"files.example.com:443", true},
+		{"notexample.com", false},
+		{"build.internal.net", true},
+		{"internal.net", true},
+		{"10.1.2.3:8080", true},
+		{"11.1.2.3", false},
+		{"192.168.1.5", true},
+		{"192.168.1.6", false},
+		{"registry.local:5000", true},
+		{"registry.local:443", false},
+		{"example.org", false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchNoProxy(noProxy, tt.host); got != tt.want {
+			t.Errorf("MatchNoProxy(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+
+	if !MatchNoProxy("*", "anything.example") {
+		t.Error("Expected * to match every host")
+	}
+	if MatchNoProxy("", "example.com") {
+		t.Error("Expected an empty list to match nothing")
+	}
+}
+
+func TestParseProxyURL(t *testing.T) {
+	for _, valid := range []string{"http://proxy:3128", "https://user:pw@proxy:443", "socks5://bastion:1080"} {
+		if _, err := ParseProxyURL(valid); err != nil {
+			t.Errorf("ParseProxyURL(%q) failed: %v", valid, err)
+		}
+	}
+	for _, invalid := range []string{"proxy:3128", "ftp://proxy:21", "socks5://"} {
+		if _, err := ParseProxyURL(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestProxyFuncFromEnvironment(t *testing.T) {
+	proxy, err := ProxyFunc("", "internal.example.com")
+	if err != nil {
+		t.Fatalf("ProxyFunc failed: %v", err)
+	}
+
+	// Excluded hosts bypass whatever proxy the environment names
+	req := httptest.NewRequest("GET", "http://files.internal.example.com/file.bin", nil)
+	if proxyURL, err := proxy(req); err != nil || proxyURL != nil {
+		t.Errorf("Expected no proxy for an excluded host, got %v, %v", proxyURL, err)
+	}
+
+	// The exclusions need a transport of their own
+	client, err := NewHTTPClient(ClientOptions{NoProxy: "internal.example.com"})
+	if err != nil {
+		t.Fatalf("NewHTTPClient failed: %v", err)
+	}
+	if client.Transport == DefaultTransport() {
+		t.Error("Expected a transport of its own for NoProxy")
+	}
+}
+
+func TestHTTPProxy(t *testing.T) {
+	// The proxy answers requests itself instead of forwarding them
+	var mu sync.Mutex
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		proxied = append(proxied, r.URL.String()+" "+r.Header.Get("Proxy-Authorization"))
+		mu.Unlock()
+		io.WriteString(w, "via proxy")
+	}))
+	defer proxy.Close()
+
+	direct := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "direct")
+	}))
+	defer direct.Close()
+
+	proxyURL := strings.Replace(proxy.URL, "http://", "http://alice:secret@", 1)
+	client, err := NewHTTPClient(ClientOptions{Proxy: proxyURL, NoProxy: "127.0.0.1"})
+	if err != nil {
+		t.Fatalf("NewHTTPClient failed: %v", err)
+	}
+
+	for url, want := range map[string]string{
+		"http://files.example.invalid/a.zip": "via proxy",
+		direct.URL:                           "direct",
+	} {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", url, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != want {
+			t.Errorf("GET %s = %q, want %q", url, body, want)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// alice:secret in basic auth
+	want := "http://files.example.invalid/a.zip Basic YWxpY2U6c2VjcmV0"
+	if len(proxied) != 1 || proxied[0] != want {
+		t.Errorf("Proxied requests = %q, want [%q]", proxied, want)
+	}
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "through the bastion")
+	}))
+	defer target.Close()
+
+	bastion, connects := startSOCKS5(t, "alice", "secret")
+	defer bastion.Close()
+
+	// The target is on localhost, which an environment NO_PROXY might exclude
+	t.Setenv("NO_PROXY", "")
+	t.Setenv("no_proxy", "")
+
+	client, err := NewHTTPClient(ClientOptions{Proxy: "socks5://alice:secret@" + bastion.Addr().String()})
+	if err != nil {
+		t.Fatalf("NewHTTPClient failed: %v", err)
+	}
+
+	resp, err := client.Get(target.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if string(body) != "through the bastion" {
+		t.Errorf("Unexpected body %q", body)
+	}
+	if got := connects(); len(got) != 1 || got[0] != strings.TrimPrefix(target.URL, "http://") {
+		t.Errorf("SOCKS5 CONNECTs = %q, want the target", got)
+	}
+}
+
+// startSOCKS5 runs a minimal SOCKS5 proxy requiring username/password auth
+// and returns it with a function listing the addresses it connected to
+func startSOCKS5(t *testing.T, user, password string) (net.Listener, func() []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	var mu sync.Mutex
+	var connects []string
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+
+				// Greeting: offer of auth methods, pick username/password
+				header := make([]byte, 2)
+				if _, err := io.ReadFull(conn, header); err != nil {
+					return
+				}
+				io.ReadFull(conn, make([]byte, header[1]))
+				conn.Write([]byte{5, 2})
+
+				// Username/password subnegotiation
+				readString := func() string {
+					n := make([]byte, 1)
+					io.ReadFull(conn, n)
+					s := make([]byte, n[0])
+					io.ReadFull(conn, s)
+					return string(s)
+				}
+				io.ReadFull(conn, make([]byte, 1))
+				if readString() != user || readString() != password {
+					conn.Write([]byte{1, 1})
+					return
+				}
+				conn.Write([]byte{1, 0})
+
+				// CONNECT request for an IPv4 address or domain name
+				req := make([]byte, 4)
+				if _, err := io.ReadFull(conn, req); err != nil {
+					return
+				}
+				var host string
+				switch req[3] {
+				case 1:
+					ip := make([]byte, 4)
+					io.ReadFull(conn, ip)
+					host = net.IP(ip).String()
+				case 3:
+					host = readString()
+				default:
+					return
+				}
+				port := make([]byte, 2)
+				io.ReadFull(conn, port)
+				addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port))))
+
+				mu.Lock()
+				connects = append(connects, addr)
+				mu.Unlock()
+
+				upstream, err := net.Dial("tcp", addr)
+				if err != nil {
+					conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer upstream.Close()
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+
+	return listener, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), connects...)
+	}
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket limiting throughput in bytes per second. It
+// is safe for concurrent use, may be shared by several downloads, and its
+// rate can be changed at any time.
+type RateLimiter struct {
+	rate   int64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+// NewRateLimiter creates a limiter allowing rate bytes per second. A rate of
+// zero or less means unlimited.
+func NewRateLimiter(rate int64) *RateLimiter {
+	return &RateLimiter{
+		rate:   rate,
+		tokens: float64(max(rate, 0)),
+		last:   time.Now(),
+	}
+}
+
+// Rate returns the current limit in bytes per second
+func (l *RateLimiter) Rate() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.rate
+}
+
+// SetRate changes the limit. A rate of zero or less means unlimited.
+func (l *RateLimiter) SetRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(time.Now())
+	l.rate = rate
+	l.tokens = min(l.tokens, float64(max(rate, 0)))
+}
+
+// WaitN blocks until n bytes may be transferred or ctx is done. Transfers
+// larger than the bucket are allowed to go into debt, which later callers
+// wait out.
+func (l *RateLimiter) WaitN(ctx context.Context, n int) error {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	return Sleep(ctx, wait)
+}
+
+// refill adds the tokens earned since the last refill, up to one second's
+// worth. Must be called with l.mu held.
+func (l *RateLimiter) refill(now time.Time) {
+	if l.rate > 0 {
+		earned := now.Sub(l.last).Seconds() * float64(l.rate)
+		l.tokens = min(l.tokens+earned, float64(l.rate))
+	}
+	l.last = now
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWaitN(t *testing.T) {
+	limiter := NewRateLimiter(100000)
+	ctx := context.Background()
+
+	// The full bucket is available at once, the next 50KB take half a second
+	start := time.Now()
+	if err := limiter.WaitN(ctx, 100000); err != nil {
+		t.Fatalf("WaitN failed: %v", err)
+	}
+	if err := limiter.WaitN(ctx, 50000); err != nil {
+		t.Fatalf("WaitN failed: %v", err)
+	}
+
+	elapsed := time.Since(start)
+	if elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected about 500ms of throttling, got %v", elapsed)
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	limiter := NewRateLimiter(0)
+
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if err := limiter.WaitN(context.Background(), 1<<20); err != nil {
+			t.Fatalf("WaitN failed: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Unlimited limiter throttled for %v", elapsed)
+	}
+}
+
+func TestRateLimiterSetRate(t *testing.T) {
+	limiter := NewRateLimiter(1000)
+	if limiter.Rate() != 1000 {
+		t.Errorf("Expected rate 1000, got %d", limiter.Rate())
+	}
+
+	// A waiter in debt is not released early, but later calls see the new rate
+	limiter.SetRate(0)
+	start := time.Now()
+	if err := limiter.WaitN(context.Background(), 1<<20); err != nil {
+		t.Fatalf("WaitN failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Expected no throttling after removing the limit, got %v", elapsed)
+	}
+}
+
+func TestRateLimiterCanceled(t *testing.T) {
+	limiter := NewRateLimiter(1000)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Ten seconds of debt must not outlast the context
+	start := time.Now()
+	err := limiter.WaitN(ctx, 10000)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("WaitN kept waiting after cancellation for %v", elapsed)
+	}
+}
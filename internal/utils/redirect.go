@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// DefaultMaxRedirects is how many redirects are followed when
+// RequestOptions.MaxRedirects is 0
+const DefaultMaxRedirects = 10
+
+// ErrTooManyRedirects is returned when a URL redirects more often than
+// allowed
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// isRedirect reports whether resp redirects to its Location
+func isRedirect(resp *http.Response) bool {
+	switch resp.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return resp.Header.Get("Location") != ""
+	}
+	return false
+}
+
+// maxRedirects returns how many redirects may be followed
+func (o *RequestOptions) maxRedirects() int {
+	switch {
+	case o == nil || o.MaxRedirects == 0:
+		return DefaultMaxRedirects
+	case o.MaxRedirects < 0:
+		return 0
+	default:
+		return o.MaxRedirects
+	}
+}
+
+// Client returns a copy of client that follows redirects like Probe does:
+// at most MaxRedirects of them, applying the options again on every hop so
+// headers and credentials only reach the hosts trusted with them
+func (o *RequestOptions) Client(client *http.Client) *http.Client {
+	redirecting := *client
+	redirecting.CheckRedirect = o.checkRedirect
+	return &redirecting
+}
+
+// checkRedirect replaces what the client copied from the previous request
+// with the options applied for the new host
+func (o *RequestOptions) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > o.maxRedirects() {
+		return fmt.Errorf("%s: %w", via[0].URL, ErrTooManyRedirects)
+	}
+	if o == nil {
+		return nil
+	}
+
+	req.Header.Del("Authorization")
+	req.Header.Del("Cookie")
+	for name := range o.Headers {
+		if name = http.CanonicalHeaderKey(name); name != "Range" && name != "If-Range" {
+			req.Header.Del(name)
+		}
+	}
+	o.Apply(req)
+	return nil
+}
+
+// followRedirects sends a request for url and follows its redirects,
+// applying opts to each hop so credentials are only sent where allowed. It
+// returns the final response and the URLs redirected to, in order.
+func followRedirects(ctx context.Context, client *http.Client, policy *RetryPolicy, opts *RequestOptions,
+	method, url string, rangeStart, rangeEnd int64) (*http.Response, []string, error) {
+	// Redirects are followed here instead of by the client
+	noFollow := *client
+	noFollow.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	var chain []string
+	for {
+		req, err := CreateHTTPRequestContext(ctx, method, url, rangeStart, rangeEnd)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts.Apply(req)
+
+		resp, err := policy.Do(&noFollow, req)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !isRedirect(resp) {
+			return resp, chain, nil
+		}
+
+		location, err := resp.Location()
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid redirect from %s: %w", url, err)
+		}
+		if len(chain) >= opts.maxRedirects() {
+			return nil, nil, fmt.Errorf("%s: %w", url, ErrTooManyRedirects)
+		}
+
+		url = location.String()
+		chain = append(chain, url)
+	}
+}
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProbeFollowsRedirects(t *testing.T) {
+	client := &http.Client{Timeout: time.Second}
+	content := "0123456789"
+
+	// The blob store rejects the credentials meant for the artifact store
+	blob := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" || r.Header.Get("X-Api-Key") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer blob.Close()
+
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); !ok || r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/file":
+			http.Redirect(w, r, "/latest/file", http.StatusMovedPermanently)
+		case "/latest/file":
+			http.Redirect(w, r, blob.URL+"/blob?sig=abc", http.StatusFound)
+		}
+	}))
+	defer store.Close()
+
+	storeURL, _ := url.Parse(store.URL)
+	opts := &RequestOptions{
+		Username:  "alice",
+		Password:  "secret",
+		Headers:   http.Header{"X-Api-Key": {"key"}},
+		AuthHosts: []string{storeURL.Host},
+	}
+
+	probe, err := Probe(context.Background(), client, store.URL+"/file", DefaultRetryPolicy(), opts)
+	if err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+
+	if probe.URL != blob.URL+"/blob?sig=abc" {
+		t.Errorf("URL = %q, want the blob URL", probe.URL)
+	}
+	wantChain := []string{store.URL + "/latest/file", blob.URL + "/blob?sig=abc"}
+	if strings.Join(probe.Redirects, " ") != strings.Join(wantChain, " ") {
+		t.Errorf("Redirects = %q, want %q", probe.Redirects, wantChain)
+	}
+	if probe.ContentLength != int64(len(content)) {
+		t.Errorf("ContentLength = %d, want %d", probe.ContentLength, len(content))
+	}
+
+	// Two redirects are one too many
+	opts.MaxRedirects = 1
+	_, err = Probe(context.Background(), client, store.URL+"/file", DefaultRetryPolicy(), opts)
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("Expected ErrTooManyRedirects, got %v", err)
+	}
+
+	// Without AuthHosts the blob store gets the credentials too
+	opts = &RequestOptions{Username: "alice", Password: "secret", Headers: http.Header{"X-Api-Key": {"key"}}}
+	_, err = Probe(context.Background(), client, store.URL+"/file", DefaultRetryPolicy(), opts)
+	var status *StatusError
+	if !errors.As(err, &status) || status.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 from the blob store, got %v", err)
+	}
+}
+
+func TestClientRedirectsApplyOptions(t *testing.T) {
+	blob := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" || r.Header.Get("X-Api-Key") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Range") != "bytes=0-9" || r.Header.Get("User-Agent") != "agent" {
+			w.WriteHeader(http.StatusExpectationFailed)
+			return
+		}
+		w.WriteHeader(http.StatusPartialContent)
+	}))
+	defer blob.Close()
+
+	store := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/file":
+			http.Redirect(w, r, "/latest/file", http.StatusFound)
+		case "/latest/file":
+			http.Redirect(w, r, blob.URL+"/blob", http.StatusFound)
+		}
+	}))
+	defer store.Close()
+
+	storeURL, _ := url.Parse(store.URL)
+	opts := &RequestOptions{
+		UserAgent:   "agent",
+		BearerToken: "token",
+		Headers:     http.Header{"X-Api-Key": {"key"}},
+		AuthHosts:   []string{storeURL.Host},
+	}
+	client := opts.Client(&http.Client{Timeout: time.Second})
+
+	get := func() (*http.Response, error) {
+		req, err := CreateHTTPRequest("GET", store.URL+"/file", 0, 9)
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		opts.Apply(req)
+		return client.Do(req)
+	}
+
+	// The store gets the credentials on both hops, the blob store none
+	resp, err := get()
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Errorf("Expected status 206, got %d", resp.StatusCode)
+	}
+
+	// Two redirects are one too many
+	opts.MaxRedirects = 1
+	_, err = get()
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("Expected ErrTooManyRedirects, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// RequestOptions adds headers, credentials and cookies to every request of
+// a download
+type RequestOptions struct {
+	// Headers are set on every request for AuthHosts, since they may carry
+	// API keys. Range and If-Range are reserved for the downloader and
+	// ignored.
+	Headers http.Header
+
+	// UserAgent replaces the default User-Agent header
+	UserAgent string
+
+	// Username and Password are sent with basic auth. Otherwise
+	// BearerToken, if set, is sent as a bearer token, or credentials for
+	// the request's host are looked up in Netrc.
+	Username    string
+	Password    string
+	BearerToken string
+	Netrc       *Netrc
+
+	// Cookies, if set, supplies the cookies sent with each request
+	Cookies http.CookieJar
+
+	// AuthHosts, if set, limits Username, Password, BearerToken and
+	// Headers to requests for these hosts, so they aren't leaked to a
+	// redirect target on another host. Netrc and Cookies are looked up per
+	// host and always apply.
+	AuthHosts []string
+
+	// MaxRedirects is how many redirects Probe and Client follow. If 0,
+	// defaults to DefaultMaxRedirects; if negative, redirects are not
+	// followed.
+	MaxRedirects int
+}
+
+// Apply adds the options to req. A nil RequestOptions leaves req unchanged.
+func (o *RequestOptions) Apply(req *http.Request) {
+	if o == nil {
+		return
+	}
+
+	if o.UserAgent != "" {
+		req.Header.Set("User-Agent", o.UserAgent)
+	}
+
+	trusted := o.trusts(req.URL.Host)
+
+	switch {
+	case o.Username != "" && trusted:
+		req.SetBasicAuth(o.Username, o.Password)
+	case o.BearerToken != "" && trusted:
+		req.Header.Set("Authorization", "Bearer "+o.BearerToken)
+	case o.Netrc != nil:
+		if login, password, ok := o.Netrc.Lookup(req.URL.Hostname()); ok {
+			req.SetBasicAuth(login, password)
+		}
+	}
+
+	if o.Cookies != nil {
+		for _, cookie := range o.Cookies.Cookies(req.URL) {
+			req.AddCookie(cookie)
+		}
+	}
+
+	// Explicit headers win over the options above
+	if !trusted {
+		return
+	}
+	for name, values := range o.Headers {
+		name = http.CanonicalHeaderKey(name)
+		if name == "Range" || name == "If-Range" {
+			continue
+		}
+		req.Header[name] = values
+	}
+}
+
+// trusts reports whether credentials may be sent to host
+func (o *RequestOptions) trusts(host string) bool {
+	if len(o.AuthHosts) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(o.AuthHosts, func(h string) bool {
+		return strings.EqualFold(h, host)
+	})
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRequestOptionsApply(t *testing.T) {
+	jar, err := ParseCookies(strings.NewReader("example.com\tFALSE\t/\tFALSE\t0\tsession\tabc123\n"))
+	if err != nil {
+		t.Fatalf("ParseCookies failed: %v", err)
+	}
+	netrc, err := ParseNetrc(strings.NewReader("machine example.com login alice password secret\n"))
+	if err != nil {
+		t.Fatalf("ParseNetrc failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		opts     *RequestOptions
+		wantAuth string
+	}{
+		{name: "nil", opts: nil},
+		{name: "basic", opts: &RequestOptions{Username: "bob", Password: "pw"}, wantAuth: "Basic Ym9iOnB3"},
+		{name: "bearer", opts: &RequestOptions{BearerToken: "token"}, wantAuth: "Bearer token"},
+		{name: "netrc", opts: &RequestOptions{Netrc: netrc}, wantAuth: "Basic YWxpY2U6c2VjcmV0"},
+		{name: "basic over netrc", opts: &RequestOptions{Username: "bob", Password: "pw", Netrc: netrc}, wantAuth: "Basic Ym9iOnB3"},
+		{name: "header over bearer", opts: &RequestOptions{
+			BearerToken: "token",
+			Headers:     http.Header{"Authorization": {"Token xyz"}},
+		}, wantAuth: "Token xyz"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := CreateHTTPRequest("GET", "https://example.com/file.zip", 0, 99)
+			if err != nil {
+				t.Fatalf("CreateHTTPRequest failed: %v", err)
+			}
+			tt.opts.Apply(req)
+
+			if got := req.Header.Get("Authorization"); got != tt.wantAuth {
+				t.Errorf("Authorization = %q, want %q", got, tt.wantAuth)
+			}
+			if got := req.Header.Get("Range"); got != "bytes=0-99" {
+				t.Errorf("Range = %q, want bytes=0-99", got)
+			}
+		})
+	}
+
+	req, err := CreateHTTPRequest("GET", "https://example.com/file.zip", 0, 99)
+	if err != nil {
+		t.Fatalf("CreateHTTPRequest failed: %v", err)
+	}
+	opts := &RequestOptions{
+		UserAgent: "test-agent",
+		Headers:   http.Header{"X-Api-Key": {"k"}, "Range": {"bytes=5-"}},
+		Cookies:   jar,
+	}
+	opts.Apply(req)
+
+	if got := req.Header.Get("User-Agent"); got != "test-agent" {
+		t.Errorf("User-Agent = %q, want test-agent", got)
+	}
+	if got := req.Header.Get("X-Api-Key"); got != "k" {
+		t.Errorf("X-Api-Key = %q, want k", got)
+	}
+	if got := req.Header.Get("Range"); got != "bytes=0-99" {
+		t.Errorf("Expected Range to be kept, got %q", got)
+	}
+	if cookie, err := req.Cookie("session"); err != nil || cookie.Value != "abc123" {
+		t.Errorf("Expected the session cookie, got %v, %v", cookie, err)
+	}
+
+	// Cookies are only sent to their own domain
+	other, _ := url.Parse("https://other.example.org/")
+	if cookies := jar.Cookies(other); len(cookies) != 0 {
+		t.Errorf("Expected no cookies for another host, got %v", cookies)
+	}
+}
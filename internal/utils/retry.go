@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// DefaultRetryableStatus lists the status codes retried by default. Other
+// codes, such as 403 and 404, are returned immediately.
+var DefaultRetryableStatus = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooEarly,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// RetryPolicy decides whether and when a failed request is sent again. The
+// delay grows exponentially from BaseDelay up to MaxDelay, randomized by
+// Jitter. A Retry-After header on a 429 or 503 response overrides the
+// computed delay.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first
+	MaxAttempts int
+
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// Jitter randomizes each delay by up to this fraction of it, in [0, 1]
+	Jitter float64
+
+	// RetryableStatus lists the status codes worth retrying
+	RetryableStatus []int
+
+	// RetryNetworkErrors retries requests that got no response at all
+	RetryNetworkErrors bool
+}
+
+// DefaultRetryPolicy returns the policy used when none is configured
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:        maxRetries,
+		BaseDelay:          retryInterval,
+		MaxDelay:           30 * time.Second,
+		Jitter:             0.2,
+		RetryableStatus:    DefaultRetryableStatus,
+		RetryNetworkErrors: true,
+	}
+}
+
+// Do sends the request until it succeeds with 200 or 206, fails in a way
+// the policy doesn't retry, or runs out of attempts. A redirect the client
+// didn't follow is returned as is. Waiting between attempts stops early
+// when the request's context is done. The request must not have a body.
+func (p *RetryPolicy) Do(client *http.Client, req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Do(req)
+		if err == nil {
+			if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent || isRedirect(resp) {
+				return resp, nil
+			}
+			resp.Body.Close()
+			err = &StatusError{StatusCode: resp.StatusCode}
+		}
+
+		if attempt >= p.MaxAttempts || !p.Retryable(req.Context(), resp, err) {
+			return nil, err
+		}
+
+		if sleepErr := Sleep(req.Context(), p.Delay(attempt, resp)); sleepErr != nil {
+			return nil, sleepErr
+		}
+	}
+}
+
+// Retryable reports whether a request that got resp and err should be sent
+// again
+func (p *RetryPolicy) Retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var status *StatusError
+	if errors.As(err, &status) {
+		return slices.Contains(p.RetryableStatus, status.StatusCode)
+	}
+	if errors.Is(err, ErrTooManyRedirects) {
+		return false
+	}
+	return p.RetryNetworkErrors
+}
+
+// Delay returns how long to wait after the given failed attempt, counted
+// from 1. resp may be nil.
+func (p *RetryPolicy) Delay(attempt int, resp *http.Response) time.Duration {
+	if wait, ok := retryAfter(resp); ok {
+		return wait
+	}
+
+	delay := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if p.MaxDelay > 0 {
+		delay = min(delay, float64(p.MaxDelay))
+	}
+	delay += delay * p.Jitter * (2*rand.Float64() - 1)
+
+	return time.Duration(max(delay, 0))
+}
+
+// retryAfter returns the wait requested by the Retry-After header of a 429
+// or 503 response, given either in seconds or as an HTTP date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil || (resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable) {
+		return 0, false
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
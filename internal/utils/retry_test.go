@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := &RetryPolicy{
+		BaseDelay: 100 * time.Millisecond,
+		MaxDelay:  time.Second,
+	}
+
+	// Exponential backoff up to MaxDelay
+	expected := []time.Duration{100, 200, 400, 800, 1000, 1000}
+	for i, want := range expected {
+		if delay := policy.Delay(i+1, nil); delay != want*time.Millisecond {
+			t.Errorf("Attempt %d: expected %v, got %v", i+1, want*time.Millisecond, delay)
+		}
+	}
+
+	// Jitter stays within the configured fraction
+	policy.Jitter = 0.5
+	for range 100 {
+		if delay := policy.Delay(1, nil); delay < 50*time.Millisecond || delay > 150*time.Millisecond {
+			t.Fatalf("Jittered delay %v out of range", delay)
+		}
+	}
+
+	// Retry-After on 429 and 503 overrides the backoff, even beyond MaxDelay
+	resp := &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	resp.Header.Set("Retry-After", "5")
+	if delay := policy.Delay(1, resp); delay != 5*time.Second {
+		t.Errorf("Expected Retry-After of 5s, got %v", delay)
+	}
+
+	resp.StatusCode = http.StatusServiceUnavailable
+	resp.Header.Set("Retry-After", time.Now().Add(3*time.Second).UTC().Format(http.TimeFormat))
+	if delay := policy.Delay(1, resp); delay < time.Second || delay > 3*time.Second {
+		t.Errorf("Expected Retry-After date about 3s away, got %v", delay)
+	}
+
+	// Other status codes ignore it
+	resp.StatusCode = http.StatusInternalServerError
+	if delay := policy.Delay(1, resp); delay > 150*time.Millisecond {
+		t.Errorf("Expected Retry-After to be ignored on 500, got %v", delay)
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	client := &http.Client{Timeout: time.Second}
+	policy := DefaultRetryPolicy()
+	policy.BaseDelay = time.Millisecond
+
+	var attempts atomic.Int32
+	status := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	// The first 429 is waited out for the requested second, then 404 is final
+	req, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	start := time.Now()
+	_, err = policy.Do(client, req)
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected a 404 StatusError, got %v", err)
+	}
+	if attempts.Load() != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts.Load())
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected Retry-After to be honoured, retried after %v", elapsed)
+	}
+
+	// Retryable codes are tried MaxAttempts times
+	attempts.Store(1)
+	status = http.StatusBadGateway
+	if _, err := policy.Do(client, req); err == nil {
+		t.Error("Expected the request to fail")
+	}
+	if attempts.Load() != 1+int32(policy.MaxAttempts) {
+		t.Errorf("Expected %d attempts, got %d", policy.MaxAttempts, attempts.Load()-1)
+	}
+
+	// Network errors are only retried if the policy allows it
+	server.Close()
+	policy.RetryNetworkErrors = false
+	start = time.Now()
+	if _, err := policy.Do(client, req); err == nil {
+		t.Error("Expected the request to a closed server to fail")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected no retries, took %v", elapsed)
+	}
+}
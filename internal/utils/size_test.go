@@ -0,0 +1,30 @@
+package utils
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	valid := map[string]int64{
+		"512":  512,
+		"500K": 500 * 1024,
+		"5M":   5 * 1024 * 1024,
+		"5mb":  5 * 1024 * 1024,
+		"5MiB": 5 * 1024 * 1024,
+		"1.5G": 1536 * 1024 * 1024,
+		" 2k ": 2048,
+		"100B": 100,
+	}
+	for s, expected := range valid {
+		size, err := ParseSize(s)
+		if err != nil {
+			t.Errorf("ParseSize(%q) failed: %v", s, err)
+		} else if size != expected {
+			t.Errorf("ParseSize(%q) = %d, expected %d", s, size, expected)
+		}
+	}
+
+	for _, s := range []string{"", "M", "abc", "-5M", "5X"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+}
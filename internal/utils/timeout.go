@@ -0,0 +1,246 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Default timeouts, used for the zero fields of Timeouts
+const (
+	DefaultDialTimeout           = 30 * time.Second
+	DefaultTLSHandshakeTimeout   = 10 * time.Second
+	DefaultResponseHeaderTimeout = 30 * time.Second
+	DefaultStallTimeout          = 60 * time.Second
+	DefaultMinSpeedTime          = 30 * time.Second
+)
+
+var (
+	// ErrStalled is returned when a response body delivers no bytes for
+	// longer than the stall timeout
+	ErrStalled = errors.New("connection stalled")
+
+	// ErrTooSlow is returned when a response body arrives slower than the
+	// minimum speed
+	ErrTooSlow = errors.New("connection below minimum speed")
+)
+
+// Timeouts bounds each phase of a request. Unlike http.Client.Timeout, none
+// of them limits how long a body that keeps arriving may take. A zero
+// duration means the default and a negative one disables the timeout.
+type Timeouts struct {
+	// Dial bounds connecting to the server
+	Dial time.Duration
+
+	// TLSHandshake bounds the TLS handshake
+	TLSHandshake time.Duration
+
+	// ResponseHeader bounds the wait for the response headers once the
+	// request is sent
+	ResponseHeader time.Duration
+
+	// Stall aborts a response whose body delivers no bytes for this long
+	Stall time.Duration
+
+	// MinSpeed, if positive, aborts a response whose body arrives slower
+	// than MinSpeed bytes per second over MinSpeedTime
+	MinSpeed     int64
+	MinSpeedTime time.Duration
+}
+
+// withDefaults returns t with zero durations replaced by their defaults and
+// negative ones by 0
+func (t Timeouts) withDefaults() Timeouts {
+	t.Dial = timeoutOrDefault(t.Dial, DefaultDialTimeout)
+	t.TLSHandshake = timeoutOrDefault(t.TLSHandshake, DefaultTLSHandshakeTimeout)
+	t.ResponseHeader = timeoutOrDefault(t.ResponseHeader, DefaultResponseHeaderTimeout)
+	t.Stall = timeoutOrDefault(t.Stall, DefaultStallTimeout)
+	t.MinSpeedTime = timeoutOrDefault(t.MinSpeedTime, DefaultMinSpeedTime)
+	return t
+}
+
+func timeoutOrDefault(timeout, def time.Duration) time.Duration {
+	switch {
+	case timeout == 0:
+		return def
+	case timeout < 0:
+		return 0
+	}
+	return timeout
+}
+
+// defaultTransport reports whether t configures a transport like the
+// shared DefaultTransport
+func (t Timeouts) defaultTransport() bool {
+	t = t.withDefaults()
+	return t.Dial == DefaultDialTimeout &&
+		t.TLSHandshake == DefaultTLSHandshakeTimeout &&
+		t.ResponseHeader == DefaultResponseHeaderTimeout
+}
+
+// apply sets the connection timeouts of transport
+func (t Timeouts) apply(transport *http.Transport) {
+	t = t.withDefaults()
+	dialer := &net.Dialer{
+		Timeout:   t.Dial,
+		KeepAlive: 30 * time.Second,
+	}
+	transport.DialContext = dialer.DialContext
+	transport.TLSHandshakeTimeout = t.TLSHandshake
+	transport.ResponseHeaderTimeout = t.ResponseHeader
+}
+
+// Watch returns a context for a request whose response body is watched for
+// stalls and slow transfers. Once the headers have arrived, Start begins
+// watching; reads are reported with Received. If the body stalls or is too
+// slow, the context is canceled and Err returns ErrStalled or ErrTooSlow.
+// Stop must be called when the request is done.
+func (t Timeouts) Watch(ctx context.Context) (context.Context, *Watchdog) {
+	t = t.withDefaults()
+	ctx, cancel := context.WithCancelCause(ctx)
+	w := &Watchdog{
+		stall:    t.Stall,
+		minSpeed: t.MinSpeed,
+		window:   t.MinSpeedTime,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	return ctx, w
+}
+
+// Watchdog aborts a response body that stalls or arrives too slowly
+type Watchdog struct {
+	stall    time.Duration
+	minSpeed int64
+	window   time.Duration
+
+	cancel   context.CancelCauseFunc
+	done     chan struct{}
+	stopOnce sync.Once
+
+	mu          sync.Mutex
+	err         error
+	last        time.Time
+	windowStart time.Time
+	windowBytes int64
+	pausedAt    time.Time
+}
+
+// Start begins watching the body
+func (w *Watchdog) Start() {
+	var interval time.Duration
+	if w.stall > 0 {
+		interval = w.stall
+	}
+	if w.minSpeed > 0 && (interval == 0 || w.window < interval) {
+		interval = w.window
+	}
+	if interval == 0 {
+		return
+	}
+	interval = min(max(interval/4, 10*time.Millisecond), time.Second)
+
+	now := time.Now()
+	w.mu.Lock()
+	w.last = now
+	w.windowStart = now
+	w.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.done:
+				return
+			case now := <-ticker.C:
+				if err := w.check(now); err != nil {
+					w.mu.Lock()
+					w.err = err
+					w.mu.Unlock()
+					w.cancel(err)
+					return
+				}
+			}
+		}
+	}()
+}
+
+// check returns ErrStalled or ErrTooSlow if the body has been stalled or
+// too slow at now
+func (w *Watchdog) check(now time.Time) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.pausedAt.IsZero() {
+		return nil
+	}
+
+	if w.stall > 0 && now.Sub(w.last) > w.stall {
+		return ErrStalled
+	}
+
+	if elapsed := now.Sub(w.windowStart); w.minSpeed > 0 && elapsed >= w.window {
+		if float64(w.windowBytes)/elapsed.Seconds() < float64(w.minSpeed) {
+			return ErrTooSlow
+		}
+		w.windowStart = now
+		w.windowBytes = 0
+	}
+
+	return nil
+}
+
+// Received records n bytes read from the body
+func (w *Watchdog) Received(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.last = time.Now()
+	w.windowBytes += int64(n)
+}
+
+// Pause stops watching until Resume, e.g. while a rate limiter holds back
+// the reads. The paused time counts neither as a stall nor against the
+// speed.
+func (w *Watchdog) Pause() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pausedAt = time.Now()
+}
+
+// Resume continues watching after Pause
+func (w *Watchdog) Resume() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.pausedAt.IsZero() {
+		return
+	}
+	paused := time.Since(w.pausedAt)
+	w.last = w.last.Add(paused)
+	w.windowStart = w.windowStart.Add(paused)
+	w.pausedAt = time.Time{}
+}
+
+// Err returns ErrStalled or ErrTooSlow if the watchdog aborted the request,
+// or nil
+func (w *Watchdog) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.err
+}
+
+// Stop stops watching and releases the request's context
+func (w *Watchdog) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.done)
+		w.cancel(nil)
+	})
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTimeoutsWithDefaults(t *testing.T) {
+	timeouts := Timeouts{Dial: 5 * time.Second, Stall: -1}.withDefaults()
+
+	if timeouts.Dial != 5*time.Second {
+		t.Errorf("Expected dial timeout 5s, got %v", timeouts.Dial)
+	}
+	if timeouts.ResponseHeader != DefaultResponseHeaderTimeout {
+		t.Errorf("Expected default header timeout, got %v", timeouts.ResponseHeader)
+	}
+	if timeouts.Stall != 0 {
+		t.Errorf("Expected a disabled stall timeout, got %v", timeouts.Stall)
+	}
+
+	if !(Timeouts{Stall: time.Second, MinSpeed: 1024}).defaultTransport() {
+		t.Error("Expected body timeouts to keep the shared transport")
+	}
+	if (Timeouts{Dial: time.Second}).defaultTransport() {
+		t.Error("Expected a custom dial timeout to need a transport of its own")
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeouts Timeouts
+		// feed reports bytes to the watchdog while the test waits
+		feed    func(w *Watchdog)
+		wantErr error
+	}{
+		{
+			name:     "stalled",
+			timeouts: Timeouts{Stall: 50 * time.Millisecond},
+			feed:     func(w *Watchdog) {},
+			wantErr:  ErrStalled,
+		},
+		{
+			name:     "too slow",
+			timeouts: Timeouts{Stall: -1, MinSpeed: 1 << 20, MinSpeedTime: 50 * time.Millisecond},
+			feed: func(w *Watchdog) {
+				for range 20 {
+					w.Received(10)
+					time.Sleep(10 * time.Millisecond)
+				}
+			},
+			wantErr: ErrTooSlow,
+		},
+		{
+			name:     "steady",
+			timeouts: Timeouts{Stall: 50 * time.Millisecond, MinSpeed: 1000, MinSpeedTime: 50 * time.Millisecond},
+			feed: func(w *Watchdog) {
+				for range 20 {
+					w.Received(100)
+					time.Sleep(10 * time.Millisecond)
+				}
+			},
+		},
+		{
+			name:     "paused",
+			timeouts: Timeouts{Stall: 50 * time.Millisecond},
+			feed: func(w *Watchdog) {
+				w.Pause()
+				time.Sleep(200 * time.Millisecond)
+				w.Resume()
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, watchdog := tt.timeouts.Watch(context.Background())
+			defer watchdog.Stop()
+
+			watchdog.Start()
+			tt.feed(watchdog)
+			if tt.wantErr != nil {
+				select {
+				case <-ctx.Done():
+				case <-time.After(time.Second):
+					t.Fatal("Expected the context to be canceled")
+				}
+			}
+
+			if err := watchdog.Err(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Err() = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil && !errors.Is(context.Cause(ctx), tt.wantErr) {
+				t.Errorf("Expected the context's cause to be %v, got %v", tt.wantErr, context.Cause(ctx))
+			}
+			if tt.wantErr == nil && ctx.Err() != nil {
+				t.Errorf("Expected the context to be alive, got %v", ctx.Err())
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePEM writes a PEM block to a file in dir and returns its path
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+// newClientCert creates a self-signed client certificate and returns it
+// with the paths of its certificate and key files
+func newClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	return cert, writePEM(t, dir, "client.pem", "CERTIFICATE", der), writePEM(t, dir, "client-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+func TestTLSOptions(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "tls_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	clientCert, certFile, keyFile := newClientCert(t, tempDir)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	// The server has a private CA and requires a client certificate
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0) // rejected handshakes are expected
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := writePEM(t, tempDir, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+	pin := "sha256//" + SPKIHash(server.Certificate())
+	clientCerts := []ClientCert{{CertFile: certFile, KeyFile: keyFile}}
+
+	tests := []struct {
+		name    string
+		opts    *TLSOptions
+		wantErr bool
+	}{
+		{name: "system roots", opts: &TLSOptions{ClientCerts: clientCerts}, wantErr: true},
+		{name: "no client certificate", opts: &TLSOptions{CAFiles: []string{caFile}}, wantErr: true},
+		{name: "private CA", opts: &TLSOptions{CAFiles: []string{caFile}, ClientCerts: clientCerts}},
+		{name: "pinned", opts: &TLSOptions{CAFiles: []string{caFile}, ClientCerts: clientCerts, PinnedKeys: []string{pin}}},
+		{name: "pin mismatch", opts: &TLSOptions{CAFiles: []string{caFile}, ClientCerts: clientCerts, PinnedKeys: []string{"sha256//AAAA"}}, wantErr: true},
+		{name: "insecure", opts: &TLSOptions{InsecureSkipVerify: true, ClientCerts: clientCerts}},
+		{name: "TLS 1.3", opts: &TLSOptions{CAFiles: []string{caFile}, ClientCerts: clientCerts, MinVersion: tls.VersionTLS13}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewHTTPClient(ClientOptions{TLS: tt.opts})
+			if err != nil {
+				t.Fatalf("NewHTTPClient failed: %v", err)
+			}
+
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GET error = %v, want error: %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// A pin mismatch is reported as such
+	client, err := NewHTTPClient(ClientOptions{TLS: &TLSOptions{
+		InsecureSkipVerify: true,
+		ClientCerts:        clientCerts,
+		PinnedKeys:         []string{"sha256//AAAA"},
+	}})
+	if err != nil {
+		t.Fatalf("NewHTTPClient failed: %v", err)
+	}
+	if _, err := client.Get(server.URL); !errors.Is(err, ErrPinMismatch) {
+		t.Errorf("Expected ErrPinMismatch, got %v", err)
+	}
+
+	// Missing files fail when the client is built
+	if _, err := NewHTTPClient(ClientOptions{TLS: &TLSOptions{CAFiles: []string{filepath.Join(tempDir, "missing.pem")}}}); err == nil {
+		t.Error("Expected an error for a missing CA file")
+	}
+	if _, err := NewHTTPClient(ClientOptions{TLS: &TLSOptions{ClientCerts: []ClientCert{{CertFile: caFile}}}}); err == nil {
+		t.Error("Expected an error for a certificate without a key")
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13, "TLS1.3": tls.VersionTLS13}
+	for input, want := range tests {
+		if got, err := ParseTLSVersion(input); err != nil || got != want {
+			t.Errorf("ParseTLSVersion(%q) = %x, %v; want %x", input, got, err, want)
+		}
+	}
+
+	if _, err := ParseTLSVersion("2.0"); err == nil {
+		t.Error("Expected an error for an unknown version")
+	}
+}
@@ -0,0 +1,186 @@
+package downloader
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/godownloader/internal/download"
+)
+
+// BatchItem is one file of a batch download
+type BatchItem struct {
+	URL string
+
+	// Output file path, relative to Options.OutputDir if set. If empty, the
+	// name is resolved from the response and the URL.
+	OutputPath string
+
+	// Expected checksum, overriding Options.Checksum for this file
+	Checksum *Checksum
+}
+
+// BatchResult reports the outcome of one file of a batch download
+type BatchResult struct {
+	Item BatchItem
+	Err  error
+
+	// Result of a download that didn't fail
+	Result Result
+}
+
+// Batch downloads several files, a few at a time. Each file is downloaded
+// with the batch's Options, using its own NumThreads connections. Files
+// resolving to the same output path are downloaded one after the other, so
+// Options.OnExists decides what the later ones do.
+type Batch struct {
+	// Options applied to every file. OutputPath, PartPath and Mirrors are
+	// ignored; each item names its own output, inside OutputDir if set.
+	// RateLimit applies to each file on its own; set Limiter to cap the
+	// whole batch.
+	Options Options
+
+	// Number of files downloaded at once. If <= 0, files are downloaded one
+	// at a time.
+	Concurrency int
+
+	items []BatchItem
+	mu    sync.Mutex
+}
+
+// NewBatch creates a batch downloading concurrency files at once
+func NewBatch(options Options, concurrency int) *Batch {
+	return &Batch{
+		Options:     options,
+		Concurrency: concurrency,
+	}
+}
+
+// Add queues files for download
+func (b *Batch) Add(items ...BatchItem) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.items = append(b.items, items...)
+}
+
+// Items returns the queued files
+func (b *Batch) Items() []BatchItem {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return append([]BatchItem(nil), b.items...)
+}
+
+// Run downloads every queued file and returns one result per file, in the
+// order they were added. A failed file doesn't stop the others. Once ctx is
+// done, files not yet started fail with the context's error.
+func (b *Batch) Run(ctx context.Context) []BatchResult {
+	items := b.Items()
+	results := make([]BatchResult, len(items))
+
+	slots := make(chan struct{}, max(b.Concurrency, 1))
+	locks := &download.PathLocks{}
+	var wg sync.WaitGroup
+
+	for i, item := range items {
+		results[i].Item = item
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *BatchResult) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result.Result, result.Err = b.download(ctx, result.Item, locks)
+		}(&results[i])
+	}
+
+	wg.Wait()
+	return results
+}
+
+// download fetches one file of the batch, taking turns with the others on
+// its output path
+func (b *Batch) download(ctx context.Context, item BatchItem, locks *download.PathLocks) (Result, error) {
+	options := b.Options
+	options.OutputPath = item.OutputPath
+	if options.OutputPath != "" && options.OutputDir != "" && !filepath.IsAbs(options.OutputPath) {
+		options.OutputPath = filepath.Join(options.OutputDir, options.OutputPath)
+	}
+	options.Mirrors = nil
+	options.PartPath = ""
+	if item.Checksum != nil {
+		options.Checksum = item.Checksum
+	}
+
+	dl := WithOptions(item.URL, options)
+	dl.pathLocks = locks
+	if err := dl.DownloadContext(ctx); err != nil {
+		return Result{}, err
+	}
+	return dl.Result(), nil
+}
+
+// ParseBatchList reads a list of files to download, one per line, as
+//
+//	URL [output] [algorithm:hexdigest]
+//
+// Blank lines and lines starting with # are skipped.
+func ParseBatchList(r io.Reader) ([]BatchItem, error) {
+	var items []BatchItem
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		item := BatchItem{URL: fields[0]}
+		for _, field := range fields[1:] {
+			switch {
+			case isChecksum(field) && item.Checksum == nil:
+				checksum, err := ParseChecksum(field)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+				}
+				item.Checksum = checksum
+			case item.OutputPath == "":
+				item.OutputPath = field
+			default:
+				return nil, fmt.Errorf("line %d: unexpected field %q", lineNumber, field)
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read list: %w", err)
+	}
+
+	return items, nil
+}
+
+// isChecksum reports whether a list field is prefixed with a supported
+// checksum algorithm
+func isChecksum(field string) bool {
+	algorithm, _, ok := strings.Cut(field, ":")
+	if !ok {
+		return false
+	}
+
+	_, err := (&Checksum{Algorithm: strings.ToLower(algorithm)}).NewHash()
+	return err == nil
+}
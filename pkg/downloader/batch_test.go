@@ -0,0 +1,171 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseBatchList(t *testing.T) {
+	digest := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	list := strings.Join([]string{
+		"# files for the nightly build",
+		"https://example.com/a.zip",
+		"",
+		"https://example.com/b.zip  b-latest.zip",
+		"https://example.com/c.zip sha256:" + digest + " c.zip",
+	}, "\n")
+
+	items, err := ParseBatchList(strings.NewReader(list))
+	if err != nil {
+		t.Fatalf("ParseBatchList failed: %v", err)
+	}
+
+	if len(items) != 3 {
+		t.Fatalf("Expected 3 items, got %d", len(items))
+	}
+	if items[0].URL != "https://example.com/a.zip" || items[0].OutputPath != "" || items[0].Checksum != nil {
+		t.Errorf("Unexpected first item: %+v", items[0])
+	}
+	if items[1].OutputPath != "b-latest.zip" {
+		t.Errorf("Expected output b-latest.zip, got %q", items[1].OutputPath)
+	}
+	if items[2].OutputPath != "c.zip" || items[2].Checksum == nil || items[2].Checksum.Digest != digest {
+		t.Errorf("Unexpected third item: %+v", items[2])
+	}
+
+	// Malformed lines report their line number
+	invalid := []string{
+		"https://example.com/a.zip sha256:abc",
+		"https://example.com/a.zip a.zip b.zip",
+	}
+	for _, list := range invalid {
+		_, err := ParseBatchList(strings.NewReader("# comment\n" + list))
+		if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+			t.Errorf("Expected a line 2 error for %q, got %v", list, err)
+		}
+	}
+}
+
+func TestBatchRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		body := "content of " + r.URL.Path
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", len(body)))
+		if r.Method != "HEAD" {
+			w.Write([]byte(body))
+		}
+	}))
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "batch_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	options := DefaultOptions()
+	options.Verbose = false
+	options.MaxRetries = 0
+
+	batch := NewBatch(options, 2)
+	batch.Add(
+		BatchItem{URL: server.URL + "/a", OutputPath: filepath.Join(tempDir, "a")},
+		BatchItem{URL: server.URL + "/missing", OutputPath: filepath.Join(tempDir, "missing")},
+		BatchItem{URL: server.URL + "/b", OutputPath: filepath.Join(tempDir, "b")},
+	)
+
+	results := batch.Run(context.Background())
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+
+	// Results keep the order of the items, and a failure doesn't stop the rest
+	for i, name := range []string{"a", "missing", "b"} {
+		if results[i].Item.URL != server.URL+"/"+name {
+			t.Errorf("Result %d is for %s", i, results[i].Item.URL)
+		}
+	}
+	if results[1].Err == nil {
+		t.Error("Expected the missing file to fail")
+	}
+	for _, i := range []int{0, 2} {
+		if results[i].Err != nil {
+			t.Errorf("Download of %s failed: %v", results[i].Item.URL, results[i].Err)
+			continue
+		}
+		data, err := os.ReadFile(results[i].Item.OutputPath)
+		if err != nil || string(data) != "content of /"+filepath.Base(results[i].Item.OutputPath) {
+			t.Errorf("Unexpected content of %s: %q (%v)", results[i].Item.OutputPath, data, err)
+		}
+	}
+
+	// Nothing starts once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, result := range batch.Run(ctx) {
+		if result.Err == nil {
+			t.Errorf("Expected %s to fail after cancellation", result.Item.URL)
+		}
+	}
+}
+
+func TestBatchRunSharedName(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(strings.Repeat(r.URL.Path, 10000)))
+	}))
+	defer server.Close()
+
+	tempDir, err := os.MkdirTemp("", "batch_test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	options := DefaultOptions()
+	options.Verbose = false
+	options.NumThreads = 4
+	options.OutputDir = tempDir
+	options.OnExists = ExistsRename
+
+	// Both URLs resolve to file.bin
+	batch := NewBatch(options, 2)
+	batch.Add(
+		BatchItem{URL: server.URL + "/one/file.bin"},
+		BatchItem{URL: server.URL + "/two/file.bin"},
+	)
+
+	results := batch.Run(context.Background())
+
+	paths := make(map[string]bool)
+	for _, result := range results {
+		if result.Err != nil {
+			t.Fatalf("Download of %s failed: %v", result.Item.URL, result.Err)
+		}
+		paths[result.Result.OutputPath] = true
+
+		data, err := os.ReadFile(result.Result.OutputPath)
+		want := strings.Repeat(strings.TrimPrefix(result.Item.URL, server.URL), 10000)
+		if err != nil || string(data) != want {
+			t.Errorf("Unexpected content of %s for %s (%v)", result.Result.OutputPath, result.Item.URL, err)
+		}
+	}
+
+	want := map[string]bool{
+		filepath.Join(tempDir, "file.bin"):     true,
+		filepath.Join(tempDir, "file (1).bin"): true,
+	}
+	if !maps.Equal(paths, want) {
+		t.Errorf("Expected outputs %v, got %v", want, paths)
+	}
+}
@@ -1,14 +1,106 @@
 package downloader
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
 	"github.com/godownloader/internal/download"
+	"github.com/godownloader/internal/utils"
 )
 
 // Options configures the downloader
 type Options struct {
-	// Output file path. If empty, derived from URL
+	// Output file path. If empty, the name suggested by the server's
+	// Content-Disposition header is used, or else the last segment of the
+	// URL path, or a name based on the content type
 	OutputPath string
 
+	// Directory the file is saved in when OutputPath is empty
+	OutputDir string
+
+	// What to do when the output file already exists. If empty, defaults
+	// to ExistsOverwrite. A partial file of an interrupted download is
+	// resumed regardless.
+	OnExists ExistsPolicy
+
+	// Where the file is written until it is complete and verified, when it
+	// is renamed to the output path. It must be on the same filesystem as
+	// the output. If empty, defaults to <output>.part.
+	PartPath string
+
+	// Set the modification time of the finished file to the server's
+	// Last-Modified
+	PreserveModTime bool
+
+	// Headers added to every request, such as API keys. Like credentials,
+	// they are only sent to the hosts of the URL and Mirrors unless
+	// TrustRedirects is set. Range and If-Range are reserved for the
+	// downloader.
+	Headers http.Header
+
+	// User-Agent sent with every request. If empty, defaults to
+	// Go-Downloader/1.0.
+	UserAgent string
+
+	// Credentials sent with basic auth, or else BearerToken sent as a
+	// bearer token
+	Username    string
+	Password    string
+	BearerToken string
+
+	// Look up basic auth credentials for each host in $NETRC or ~/.netrc,
+	// unless Username or BearerToken is set
+	Netrc bool
+
+	// Cookies sent with every request, e.g. from LoadCookies
+	Cookies http.CookieJar
+
+	// Maximum number of redirects followed when probing the URL. Chunks
+	// are then requested from the final URL directly, and any redirect of
+	// theirs is held to the same limit. If 0, defaults to 10; if negative,
+	// redirects are not followed.
+	MaxRedirects int
+
+	// Also send Username, Password, BearerToken and Headers to the hosts
+	// redirected to. By default they are only sent to the hosts of the URL
+	// and Mirrors.
+	TrustRedirects bool
+
+	// Proxy every request goes through, as an http://, https:// or
+	// socks5:// URL with optional user:password. If empty, the proxy is
+	// taken from HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+	Proxy string
+
+	// Hosts reached without a proxy, as a comma-separated list of domains
+	// (matching their subdomains too), IP addresses and CIDR ranges, or
+	// "*". With Proxy set, it replaces NO_PROXY; with the proxy from the
+	// environment, it excludes hosts in addition to NO_PROXY.
+	NoProxy string
+
+	// TLS adds trusted CAs, client certificates and pinned keys to every
+	// connection
+	TLS *TLSOptions
+
+	// HTTPClient, if set, sends every request of the download, such as a
+	// client with instrumentation or a custom transport. It can't be
+	// combined with Transport, Proxy, NoProxy or TLS, which configure the
+	// default client.
+	HTTPClient *http.Client
+
+	// Transport, if set, sends every request. If nil, the requests of all
+	// downloads share a transport keeping an idle connection per thread.
+	// It can't be combined with Proxy, NoProxy or TLS.
+	Transport http.RoundTripper
+
+	// Timeouts bound connecting, the TLS handshake and waiting for the
+	// response headers, and abort a connection whose body stalls or falls
+	// below a minimum speed so its chunk is retried. The zero value uses
+	// the defaults. HTTPClient and Transport bring their own connection
+	// timeouts.
+	Timeouts Timeouts
+
 	// Number of concurrent downloading threads
 	// If <= 0, defaults to number of CPU cores
 	NumThreads int
@@ -16,8 +108,206 @@ type Options struct {
 	// Maximum number of retries for failed chunks
 	MaxRetries int
 
+	// How each HTTP request is retried before its chunk counts as failed.
+	// If nil, DefaultRetryPolicy is used.
+	RetryPolicy *RetryPolicy
+
 	// Verbose output
 	Verbose bool
+
+	// Write chunks directly into an output file preallocated to the full
+	// size, skipping the temp files and the merge step
+	Preallocate bool
+
+	// Additional URLs serving the same file. Mirrors must report the same
+	// size and ETag as the main URL; chunks are spread across them, favouring
+	// faster mirrors, and moved to another mirror when one fails.
+	Mirrors []string
+
+	// Smallest range split off a slow chunk and handed to an idle worker.
+	// If 0, defaults to 1MB; if negative, chunks are never split.
+	MinSplitSize int64
+
+	// Expected checksum of the file. On mismatch the download fails with a
+	// *ChecksumMismatchError and the file is moved to <output>.quarantine.
+	Checksum *Checksum
+
+	// Maximum download speed in bytes per second, shared by all threads of
+	// the download. If 0, the speed is unlimited.
+	RateLimit int64
+
+	// Limiter, if set, additionally caps the combined speed of every
+	// download using it
+	Limiter *Limiter
+
+	// OnProgress, if set, receives lifecycle events and a progress update
+	// about every 100ms. Events are delivered one at a time from the
+	// download, so the callback should return quickly. The console output
+	// enabled by Verbose is rendered from the same events.
+	OnProgress func(ProgressEvent)
+}
+
+// ProgressEvent reports a lifecycle change or a periodic progress update
+type ProgressEvent = download.ProgressEvent
+
+// EventType identifies what a ProgressEvent reports
+type EventType = download.EventType
+
+// ChunkState is a snapshot of one chunk's progress
+type ChunkState = download.ChunkState
+
+// Event types, in the order a download goes through them
+const (
+	EventProbing       = download.EventProbing
+	EventDownloading   = download.EventDownloading
+	EventProgress      = download.EventProgress
+	EventChunkComplete = download.EventChunkComplete
+	EventRetrying      = download.EventRetrying
+	EventVerifying     = download.EventVerifying
+	EventMerging       = download.EventMerging
+	EventDone          = download.EventDone
+	EventSkipped       = download.EventSkipped
+	EventFailed        = download.EventFailed
+)
+
+// ExistsPolicy decides what happens when the output file already exists
+type ExistsPolicy = download.ExistsPolicy
+
+// Policies for an existing output file
+const (
+	// ExistsOverwrite replaces the file
+	ExistsOverwrite = download.ExistsOverwrite
+	// ExistsSkip keeps the file if it is current: its size matches, the
+	// ETag recorded in <output>.gdl-etag when it was downloaded matches, it
+	// is not older than the remote Last-Modified and, if Options.Checksum
+	// is set, its digest matches. Otherwise the file is replaced.
+	ExistsSkip = download.ExistsSkip
+	// ExistsFail fails the download with ErrFileExists
+	ExistsFail = download.ExistsFail
+	// ExistsRename saves to a free name such as "file (1).zip"
+	ExistsRename = download.ExistsRename
+	// ExistsResume continues a smaller file from where it ends and skips a
+	// complete one, unless its recorded ETag differs
+	ExistsResume = download.ExistsResume
+)
+
+// ErrFileExists is returned by ExistsFail when the output file exists
+var ErrFileExists = download.ErrFileExists
+
+// ParseExistsPolicy parses one of "overwrite", "skip", "fail", "rename" or
+// "resume"
+func ParseExistsPolicy(s string) (ExistsPolicy, error) {
+	return download.ParseExistsPolicy(s)
+}
+
+// TLSOptions configures TLS connections: extra root CAs, client
+// certificates, the minimum version and public key pinning.
+// InsecureSkipVerify turns off certificate verification and must only be
+// used for testing.
+type TLSOptions = utils.TLSOptions
+
+// ClientCert is a client certificate and its private key, both PEM files
+type ClientCert = utils.ClientCert
+
+// ErrPinMismatch is returned when no certificate of a server matches the
+// pinned public keys
+var ErrPinMismatch = utils.ErrPinMismatch
+
+// ParseTLSVersion parses a TLS version such as "1.2" or "1.3" for
+// TLSOptions.MinVersion
+func ParseTLSVersion(s string) (uint16, error) {
+	return utils.ParseTLSVersion(s)
+}
+
+// Timeouts bounds each phase of a request; see Options.Timeouts. A zero
+// duration means the default and a negative one disables the timeout.
+type Timeouts = utils.Timeouts
+
+// Defaults of the zero fields of Timeouts
+const (
+	DefaultDialTimeout           = utils.DefaultDialTimeout
+	DefaultTLSHandshakeTimeout   = utils.DefaultTLSHandshakeTimeout
+	DefaultResponseHeaderTimeout = utils.DefaultResponseHeaderTimeout
+	DefaultStallTimeout          = utils.DefaultStallTimeout
+	DefaultMinSpeedTime          = utils.DefaultMinSpeedTime
+)
+
+var (
+	// ErrStalled is returned when a response delivers no data for longer
+	// than Timeouts.Stall
+	ErrStalled = utils.ErrStalled
+
+	// ErrTooSlow is returned when a response arrives slower than
+	// Timeouts.MinSpeed
+	ErrTooSlow = utils.ErrTooSlow
+)
+
+// LoadCookies reads a cookie jar from a Netscape cookies.txt file, as
+// written by curl and browser extensions
+func LoadCookies(path string) (http.CookieJar, error) {
+	return utils.LoadCookies(path)
+}
+
+// Result describes a finished download
+type Result struct {
+	// Where the file was saved. This is the resolved name if
+	// Options.OutputPath was empty, or the free name picked by ExistsRename.
+	OutputPath string
+
+	// Set when an existing file was kept instead of downloading it
+	Skipped bool
+
+	// The URL the file was downloaded from after following Redirects, the
+	// URLs redirected to in order
+	URL       string
+	Redirects []string
+}
+
+// Limiter caps the combined bandwidth of several downloads
+type Limiter = utils.RateLimiter
+
+// NewLimiter creates a limiter allowing bytesPerSecond across all downloads
+// sharing it. Its rate can be changed at any time with SetRate.
+func NewLimiter(bytesPerSecond int64) *Limiter {
+	return utils.NewRateLimiter(bytesPerSecond)
+}
+
+// ParseSize parses a byte count such as "500K", "5M" or "1.5G", using
+// binary multiples
+func ParseSize(s string) (int64, error) {
+	return utils.ParseSize(s)
+}
+
+// Checksum is an expected file digest, e.g. from ParseChecksum("sha256:...")
+type Checksum = utils.Checksum
+
+// ChecksumMismatchError reports a downloaded file whose digest differs from
+// the expected one
+type ChecksumMismatchError = utils.ChecksumMismatchError
+
+// RetryPolicy decides whether and when a failed request is sent again, with
+// exponential backoff, jitter and support for Retry-After
+type RetryPolicy = utils.RetryPolicy
+
+// DefaultRetryPolicy returns the policy used when Options.RetryPolicy is
+// nil: 3 attempts, backing off from 1s to at most 30s with 20% jitter,
+// retrying network errors and 408, 425, 429, 500, 502, 503 and 504
+func DefaultRetryPolicy() *RetryPolicy {
+	return utils.DefaultRetryPolicy()
+}
+
+// ErrResourceChanged is returned when the remote file changes while it is
+// being downloaded. The partial download is discarded, so downloading again
+// starts over with the new version.
+var ErrResourceChanged = download.ErrResourceChanged
+
+// StatusError reports an HTTP response with an unexpected status code
+type StatusError = utils.StatusError
+
+// ParseChecksum parses a checksum in the form "algorithm:hexdigest". The
+// supported algorithms are sha256, sha512, sha1, md5 and blake2b.
+func ParseChecksum(s string) (*Checksum, error) {
+	return utils.ParseChecksum(s)
 }
 
 // Downloader is the public downloader interface
@@ -25,6 +315,11 @@ type Downloader struct {
 	url     string
 	options Options
 	impl    *download.Downloader
+	limiter *utils.RateLimiter
+
+	// pathLocks, set by a batch, keeps its files off each other's output
+	// paths
+	pathLocks *download.PathLocks
 }
 
 // New creates a new downloader with the given URL and output path
@@ -37,6 +332,7 @@ func New(url, outputPath string, numThreads int) *Downloader {
 			MaxRetries: 3,
 			Verbose:    true,
 		},
+		limiter: utils.NewRateLimiter(0),
 	}
 }
 
@@ -45,16 +341,116 @@ func WithOptions(url string, options Options) *Downloader {
 	return &Downloader{
 		url:     url,
 		options: options,
+		limiter: utils.NewRateLimiter(options.RateLimit),
 	}
 }
 
 // Download starts the download process
 func (d *Downloader) Download() error {
+	return d.DownloadContext(context.Background())
+}
+
+// DownloadContext starts the download process and aborts it when ctx is
+// done, returning ctx.Err(). An aborted multi-threaded download can be
+// resumed by downloading to the same output path again.
+func (d *Downloader) DownloadContext(ctx context.Context) error {
+	request, err := d.requestOptions()
+	if err != nil {
+		return err
+	}
+
 	d.impl = download.NewDownloader(d.url, d.options.OutputPath, d.options.NumThreads)
+	d.impl.SetRequestOptions(request)
+	d.impl.SetTrustRedirects(d.options.TrustRedirects)
+	if err := d.impl.SetTimeouts(d.options.Timeouts); err != nil {
+		return err
+	}
+	if d.options.HTTPClient != nil || d.options.Transport != nil {
+		if d.options.HTTPClient != nil && d.options.Transport != nil {
+			return errors.New("options HTTPClient and Transport can't be combined")
+		}
+		if d.options.Proxy != "" || d.options.NoProxy != "" || d.options.TLS != nil {
+			return errors.New("options Proxy, NoProxy and TLS can't be combined with HTTPClient or Transport")
+		}
+	}
+	if d.options.HTTPClient != nil {
+		d.impl.SetHTTPClient(d.options.HTTPClient)
+	}
+	if d.options.Transport != nil {
+		d.impl.SetTransport(d.options.Transport)
+	}
+	if d.options.Proxy != "" || d.options.NoProxy != "" {
+		if err := d.impl.SetProxy(d.options.Proxy, d.options.NoProxy); err != nil {
+			return err
+		}
+	}
+	if d.options.TLS != nil {
+		if err := d.impl.SetTLS(d.options.TLS); err != nil {
+			return err
+		}
+	}
 	d.impl.SetMaxRetries(d.options.MaxRetries)
+	if d.options.OutputDir != "" {
+		d.impl.SetOutputDir(d.options.OutputDir)
+	}
+	if d.options.OnExists != "" {
+		d.impl.SetOnExists(d.options.OnExists)
+	}
+	d.impl.SetPartPath(d.options.PartPath)
+	d.impl.SetPreserveModTime(d.options.PreserveModTime)
+	d.impl.SetRetryPolicy(d.options.RetryPolicy)
 	d.impl.SetVerbose(d.options.Verbose)
+	d.impl.SetPreallocate(d.options.Preallocate)
+	d.impl.SetChecksum(d.options.Checksum)
+	d.impl.SetMirrors(d.options.Mirrors)
+	if d.options.MinSplitSize != 0 {
+		d.impl.SetMinSplitSize(d.options.MinSplitSize)
+	}
+	// The limiter outlives the download so SetRateLimit works at any time
+	d.impl.RateLimiter = d.limiter
+	d.impl.SetSharedRateLimiter(d.options.Limiter)
+	d.impl.SetPathLocks(d.pathLocks)
+	if d.options.OnProgress != nil {
+		d.impl.Subscribe(d.options.OnProgress)
+	}
 
-	return d.impl.Start()
+	return d.impl.StartContext(ctx)
+}
+
+// requestOptions collects the headers, credentials and cookies of the options
+func (d *Downloader) requestOptions() (*utils.RequestOptions, error) {
+	request := &utils.RequestOptions{
+		Headers:      d.options.Headers,
+		UserAgent:    d.options.UserAgent,
+		Username:     d.options.Username,
+		Password:     d.options.Password,
+		BearerToken:  d.options.BearerToken,
+		Cookies:      d.options.Cookies,
+		MaxRedirects: d.options.MaxRedirects,
+	}
+
+	if d.options.Netrc {
+		netrc, err := utils.LoadNetrc(utils.DefaultNetrcPath())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read netrc: %w", err)
+		}
+		request.Netrc = netrc
+	}
+
+	return request, nil
+}
+
+// Result reports the outcome of the last download
+func (d *Downloader) Result() Result {
+	if d.impl == nil {
+		return Result{}
+	}
+	return Result{
+		OutputPath: d.impl.OutputPath,
+		Skipped:    d.impl.Skipped,
+		URL:        d.impl.ResolvedURL,
+		Redirects:  d.impl.Redirects,
+	}
 }
 
 // SetVerbose sets the verbose flag
@@ -73,6 +469,14 @@ func (d *Downloader) SetMaxRetries(maxRetries int) {
 	}
 }
 
+// SetRateLimit changes the maximum download speed in bytes per second,
+// or removes the limit if it is 0. It takes effect immediately, even while
+// a download is running.
+func (d *Downloader) SetRateLimit(bytesPerSecond int64) {
+	d.options.RateLimit = bytesPerSecond
+	d.limiter.SetRate(bytesPerSecond)
+}
+
 // DefaultOptions returns the default options
 func DefaultOptions() Options {
 	return Options{
@@ -2,6 +2,7 @@ package downloader
 
 import (
 	"errors"
+	"net/http"
 	"testing"
 )
 
@@ -189,3 +190,39 @@ func TestDefaultOptions(t *testing.T) {
 		t.Errorf("Expected Verbose to be true")
 	}
 }
+
+// TestSetRateLimit tests the SetRateLimit method
+func TestSetRateLimit(t *testing.T) {
+	d := WithOptions("https://example.com/file.zip", Options{RateLimit: 1000})
+
+	if d.limiter.Rate() != 1000 {
+		t.Errorf("Expected limiter rate 1000, got %d", d.limiter.Rate())
+	}
+
+	d.SetRateLimit(5000)
+
+	if d.options.RateLimit != 5000 || d.limiter.Rate() != 5000 {
+		t.Errorf("Expected rate limit 5000, got %d (limiter %d)", d.options.RateLimit, d.limiter.Rate())
+	}
+}
+
+// TestDownloadClientConflict tests that HTTPClient and Transport exclude the
+// options configuring the default client
+func TestDownloadClientConflict(t *testing.T) {
+	options := DefaultOptions()
+	options.OutputPath = "/tmp/test.zip"
+	options.HTTPClient = &http.Client{}
+	options.Proxy = "http://proxy.example.com:3128"
+
+	d := WithOptions("https://example.com/test.zip", options)
+	if err := d.Download(); err == nil {
+		t.Error("Expected an error for HTTPClient combined with Proxy")
+	}
+
+	options.Proxy = ""
+	options.Transport = http.DefaultTransport
+	d = WithOptions("https://example.com/test.zip", options)
+	if err := d.Download(); err == nil {
+		t.Error("Expected an error for HTTPClient combined with Transport")
+	}
+}